@@ -0,0 +1,419 @@
+package gophpfpm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FastCGI record types
+const (
+	fcgiBeginRequest uint8 = 1
+	fcgiEndRequest   uint8 = 3
+	fcgiParams       uint8 = 4
+	fcgiStdin        uint8 = 5
+	fcgiStdout       uint8 = 6
+	fcgiStderr       uint8 = 7
+)
+
+const (
+	fcgiVersion   uint8  = 1
+	fcgiResponder uint16 = 1
+	fcgiRequestID uint16 = 1
+
+	// maximum content length of a FastCGI record
+	fcgiMaxWrite = 65535
+)
+
+// fcgiHeader is the header of a FastCGI record
+type fcgiHeader struct {
+	Version       uint8
+	Type          uint8
+	RequestID     uint16
+	ContentLength uint16
+	PaddingLength uint8
+	Reserved      uint8
+}
+
+// fcgiResult is the raw result of a FastCGI request
+type fcgiResult struct {
+	stdout    []byte
+	stderr    []byte
+	appStatus int
+}
+
+// fcgiWriteRecord writes a FastCGI record of the type
+// with the content to the writer
+func fcgiWriteRecord(w io.Writer, recType uint8, content []byte) error {
+	header := fcgiHeader{
+		Version:       fcgiVersion,
+		Type:          recType,
+		RequestID:     fcgiRequestID,
+		ContentLength: uint16(len(content)),
+	}
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return err
+	}
+	_, err := w.Write(content)
+	return err
+}
+
+// fcgiWriteStream writes the content as a stream of records
+// of the type, terminated by an empty record
+func fcgiWriteStream(w io.Writer, recType uint8, content []byte) error {
+	for len(content) > 0 {
+		n := len(content)
+		if n > fcgiMaxWrite {
+			n = fcgiMaxWrite
+		}
+		if err := fcgiWriteRecord(w, recType, content[:n]); err != nil {
+			return err
+		}
+		content = content[n:]
+	}
+	return fcgiWriteRecord(w, recType, nil)
+}
+
+// fcgiEncodeSize encodes the length of a name or
+// value in FastCGI name-value pair
+func fcgiEncodeSize(buf *bytes.Buffer, size int) {
+	if size < 128 {
+		buf.WriteByte(byte(size))
+		return
+	}
+	binary.Write(buf, binary.BigEndian, uint32(size)|1<<31)
+}
+
+// fcgiEncodeParams encodes the params as FastCGI
+// name-value pairs
+func fcgiEncodeParams(params map[string]string) []byte {
+	var buf bytes.Buffer
+	for _, name := range sortedNames(params) {
+		fcgiEncodeSize(&buf, len(name))
+		fcgiEncodeSize(&buf, len(params[name]))
+		buf.WriteString(name)
+		buf.WriteString(params[name])
+	}
+	return buf.Bytes()
+}
+
+// fcgiRoundTrip sends a FastCGI request with the params and stdin
+// through the connection, then reads the response until the end
+// of request
+func fcgiRoundTrip(conn io.ReadWriter, params map[string]string, stdin []byte) (result fcgiResult, err error) {
+	w := bufio.NewWriter(conn)
+	begin := []byte{byte(fcgiResponder >> 8), byte(fcgiResponder), 0, 0, 0, 0, 0, 0}
+	if err = fcgiWriteRecord(w, fcgiBeginRequest, begin); err != nil {
+		return
+	}
+	if err = fcgiWriteStream(w, fcgiParams, fcgiEncodeParams(params)); err != nil {
+		return
+	}
+	if err = fcgiWriteStream(w, fcgiStdin, stdin); err != nil {
+		return
+	}
+	if err = w.Flush(); err != nil {
+		return
+	}
+
+	var stdout, stderr bytes.Buffer
+	r := bufio.NewReader(conn)
+	for {
+		var header fcgiHeader
+		if err = binary.Read(r, binary.BigEndian, &header); err != nil {
+			return
+		}
+		content := make([]byte, int(header.ContentLength)+int(header.PaddingLength))
+		if _, err = io.ReadFull(r, content); err != nil {
+			return
+		}
+		content = content[:header.ContentLength]
+
+		switch header.Type {
+		case fcgiStdout:
+			stdout.Write(content)
+		case fcgiStderr:
+			stderr.Write(content)
+		case fcgiEndRequest:
+			if len(content) < 8 {
+				err = fmt.Errorf("fcgi: malformed end request record")
+				return
+			}
+			if protocolStatus := content[4]; protocolStatus != 0 {
+				err = fmt.Errorf("fcgi: request rejected with protocol status %d", protocolStatus)
+				return
+			}
+			result.stdout = stdout.Bytes()
+			result.stderr = stderr.Bytes()
+			result.appStatus = int(binary.BigEndian.Uint32(content[:4]))
+			return
+		}
+	}
+}
+
+// fcgiParseResponse parses the CGI response in the stdout of
+// a FastCGI request into HTTP status code, header and body
+func fcgiParseResponse(stdout []byte) (status int, header textproto.MIMEHeader, body []byte, err error) {
+	r := bufio.NewReader(bytes.NewReader(stdout))
+	if header, err = textproto.NewReader(r).ReadMIMEHeader(); err != nil && err != io.EOF {
+		return 0, nil, nil, fmt.Errorf("fcgi: malformed response header: %s", err)
+	}
+	err = nil
+
+	status = 200
+	if statusLine := header.Get("Status"); statusLine != "" {
+		if status, err = strconv.Atoi(strings.Fields(statusLine)[0]); err != nil {
+			return 0, nil, nil, fmt.Errorf("fcgi: malformed status %#v", statusLine)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err = buf.ReadFrom(r); err != nil {
+		return
+	}
+	return status, header, buf.Bytes(), nil
+}
+
+// FcgiRequest is a FastCGI request to the pool
+type FcgiRequest struct {
+
+	// path of the script to execute (SCRIPT_FILENAME)
+	ScriptFilename string
+
+	// request method (REQUEST_METHOD). Defaults to "GET"
+	Method string
+
+	// additional FastCGI params. They override the
+	// params generated from the other attributes
+	Params map[string]string
+
+	// request body, sent to the script as stdin
+	Body []byte
+
+	// Time limit of the request once connected. Overrides
+	// the ReadTimeout of the client if set. The connection
+	// is aborted once passed, with a FcgiTimeoutError
+	Timeout time.Duration
+}
+
+// FcgiResponse is the response of a FastCGI request
+type FcgiResponse struct {
+
+	// HTTP status code of the response
+	Status int
+
+	// headers of the response
+	Header http.Header
+
+	// body of the response (stdout without the headers)
+	Body []byte
+
+	// stderr of the script
+	Stderr []byte
+
+	// application status reported by the end of request
+	AppStatus int
+}
+
+// FcgiClient sends FastCGI requests to a php-fpm pool
+type FcgiClient struct {
+
+	// network and address of the pool, as used by net.Dial
+	Network string
+	Address string
+
+	// Timeouts to connect the pool and to exchange the
+	// request once connected. Default to 5 seconds if
+	// not set. See FcgiTimeoutError.
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+}
+
+// defaultFcgiTimeout is the default of the
+// FastCGI connect and read timeouts
+const defaultFcgiTimeout = 5 * time.Second
+
+// FcgiTimeoutError is returned by FastCGI requests that time
+// out. Op is "connect" if the pool did not accept the
+// connection in time. Otherwise Op is "read" and the pool
+// accepted, but did not respond in time.
+type FcgiTimeoutError struct {
+	Op      string
+	Timeout time.Duration
+	Err     error
+}
+
+// Error implements error
+func (err *FcgiTimeoutError) Error() string {
+	return fmt.Sprintf("fcgi: %s timed out after %s: %s", err.Op, err.Timeout, err.Err)
+}
+
+// Unwrap returns the underlying error
+func (err *FcgiTimeoutError) Unwrap() error {
+	return err.Err
+}
+
+// fcgiTimeoutError wraps err in a FcgiTimeoutError
+// if it is a timeout
+func fcgiTimeoutError(op string, timeout time.Duration, err error) error {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return &FcgiTimeoutError{Op: op, Timeout: timeout, Err: err}
+	}
+	return err
+}
+
+// Client returns a FastCGI client of the pool
+// listening on the Listen address
+func (proc *Process) Client() *FcgiClient {
+	network, address := proc.Address()
+	return &FcgiClient{
+		Network:        network,
+		Address:        address,
+		ConnectTimeout: proc.FcgiConnectTimeout,
+		ReadTimeout:    proc.FcgiReadTimeout,
+	}
+}
+
+// Do sends the FastCGI request to the pool
+// and returns the response
+func (client *FcgiClient) Do(req FcgiRequest) (resp FcgiResponse, err error) {
+	return client.DoContext(context.Background(), req)
+}
+
+// DoContext sends the FastCGI request like Do does. If the
+// context is done before the response is read, the connection
+// is closed, so php-fpm aborts the request, and the context's
+// error is returned.
+func (client *FcgiClient) DoContext(ctx context.Context, req FcgiRequest) (resp FcgiResponse, err error) {
+	method := req.Method
+	if method == "" {
+		method = "GET"
+	}
+	scriptName := "/" + path.Base(req.ScriptFilename)
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"REQUEST_METHOD":    method,
+		"REQUEST_URI":       scriptName,
+		"SCRIPT_NAME":       scriptName,
+		"SCRIPT_FILENAME":   req.ScriptFilename,
+		"QUERY_STRING":      "",
+		"SERVER_PROTOCOL":   "HTTP/1.1",
+		"CONTENT_LENGTH":    strconv.Itoa(len(req.Body)),
+	}
+	for name, value := range req.Params {
+		params[name] = value
+	}
+
+	connectTimeout := durationOrDefault(client.ConnectTimeout, defaultFcgiTimeout)
+	dialer := net.Dialer{Timeout: connectTimeout}
+	conn, err := dialer.DialContext(ctx, client.Network, client.Address)
+	if err != nil {
+		if ctx.Err() != nil {
+			return resp, ctx.Err()
+		}
+		err = fcgiTimeoutError("connect", connectTimeout, err)
+		return
+	}
+	defer conn.Close()
+
+	// abort the request once the context is done
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-finished:
+		}
+	}()
+
+	readTimeout := durationOrDefault(client.ReadTimeout, defaultFcgiTimeout)
+	if req.Timeout > 0 {
+		readTimeout = req.Timeout
+	}
+	if err = conn.SetDeadline(time.Now().Add(readTimeout)); err != nil {
+		return
+	}
+	result, err := fcgiRoundTrip(conn, params, req.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return resp, ctx.Err()
+		}
+		err = fcgiTimeoutError("read", readTimeout, err)
+		return
+	}
+	status, header, body, err := fcgiParseResponse(result.stdout)
+	if err != nil {
+		return
+	}
+	return FcgiResponse{
+		Status:    status,
+		Header:    http.Header(header),
+		Body:      body,
+		Stderr:    result.stderr,
+		AppStatus: result.appStatus,
+	}, nil
+}
+
+// Exec executes the script with a GET request through
+// FastCGI and returns the response body. The params
+// are sent as additional FastCGI params. Returns error
+// along with the body if the response status is 400
+// or above.
+func (client *FcgiClient) Exec(scriptPath string, params map[string]string) ([]byte, error) {
+	return client.ExecContext(context.Background(), scriptPath, params)
+}
+
+// ExecContext executes the script like Exec does. If the
+// context is done first, the connection is closed and the
+// context's error is returned. See DoContext.
+func (client *FcgiClient) ExecContext(ctx context.Context, scriptPath string, params map[string]string) ([]byte, error) {
+	resp, err := client.DoContext(ctx, FcgiRequest{
+		ScriptFilename: scriptPath,
+		Params:         params,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status >= 400 {
+		return resp.Body, fmt.Errorf("fcgi: %s responded with status %d",
+			scriptPath, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// fcgiGet sends a GET request of the path to the pool
+// and returns the response body. Returns error if the
+// response status is not 200.
+func (proc *Process) fcgiGet(path string) (body []byte, err error) {
+	script, query := path, ""
+	if i := strings.Index(path, "?"); i >= 0 {
+		script, query = path[:i], path[i+1:]
+	}
+	resp, err := proc.Client().Do(FcgiRequest{
+		ScriptFilename: script,
+		Params: map[string]string{
+			"REQUEST_URI":  path,
+			"SCRIPT_NAME":  script,
+			"QUERY_STRING": query,
+		},
+	})
+	if err != nil {
+		return
+	}
+	if resp.Status != 200 {
+		return nil, fmt.Errorf("fcgi: %s responded with status %d: %s",
+			path, resp.Status, bytes.TrimSpace(resp.Body))
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,237 @@
+package gophpfpm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gophpfpm"
+)
+
+func TestFcgiClient_Do(t *testing.T) {
+	l := serveFcgi(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		env := fcgi.ProcessEnv(r)
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Script-Filename", env["SCRIPT_FILENAME"])
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s %s", r.Method, env["APP_ENV"], body)
+	}))
+	defer l.Close()
+
+	process := &gophpfpm.Process{}
+	process.Listen = l.Addr().String()
+
+	resp, err := process.Client().Do(gophpfpm.FcgiRequest{
+		ScriptFilename: "/var/www/index.php",
+		Method:         "POST",
+		Params: map[string]string{
+			"APP_ENV":      "testing",
+			"CONTENT_TYPE": "text/plain",
+		},
+		Body: []byte("hello"),
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := http.StatusCreated, resp.Status; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "/var/www/index.php", resp.Header.Get("X-Script-Filename"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "POST testing hello", string(resp.Body); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestFcgiClient_Exec(t *testing.T) {
+	l := serveFcgi(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		env := fcgi.ProcessEnv(r)
+		if env["SCRIPT_FILENAME"] != "/var/www/index.php" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "File not found.")
+			return
+		}
+		fmt.Fprintf(w, "hello %s", env["NAME"])
+	}))
+	defer l.Close()
+
+	process := &gophpfpm.Process{}
+	process.Listen = l.Addr().String()
+
+	body, err := process.Client().Exec("/var/www/index.php", map[string]string{"NAME": "world"})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if want, have := "hello world", string(body); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	body, err = process.Client().Exec("/var/www/not-exists.php", nil)
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if want, have := "File not found.", string(body); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestFcgiClient_ExecPhpFpm(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.exec.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	defer process.Wait()
+	defer process.Stop()
+
+	body, err := process.Client().Exec(basepath+"/var/www/index.php", nil)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if want, have := "hello index", string(body); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestFcgiClient_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	l := serveFcgi(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		fmt.Fprint(w, "pong")
+	}))
+	defer l.Close()
+	defer close(release)
+
+	process := &gophpfpm.Process{}
+	process.Listen = l.Addr().String()
+	process.PingPath = "/ping"
+	process.FcgiReadTimeout = 20 * time.Millisecond
+
+	start := time.Now()
+	err := process.Ping()
+	var timeoutErr *gophpfpm.FcgiTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Errorf("expected FcgiTimeoutError, got %#v", err)
+		return
+	}
+	if want, have := "read", timeoutErr.Op; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 20*time.Millisecond, timeoutErr.Timeout; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected to time out in 20ms, took %s", elapsed)
+	}
+
+	// a refused connection is not a timeout
+	l.Close()
+	if err := process.Ping(); err == nil || errors.As(err, &timeoutErr) {
+		t.Errorf("expected non-timeout error, got %#v", err)
+	}
+}
+
+func TestFcgiClient_RequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fcgi.ProcessEnv(r)["SCRIPT_FILENAME"] == "/slow.php" {
+			<-release
+		}
+		fmt.Fprint(w, "done")
+	})
+
+	socket := basepath + "/var/test.requesttimeout.sock"
+	os.Remove(socket)
+	unixListener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer unixListener.Close()
+	go fcgi.Serve(unixListener, handler)
+	tcpListener := serveFcgi(t, handler)
+	defer tcpListener.Close()
+
+	for _, l := range []net.Listener{unixListener, tcpListener} {
+		client := &gophpfpm.FcgiClient{
+			Network: l.Addr().Network(),
+			Address: l.Addr().String(),
+		}
+
+		resp, err := client.Do(gophpfpm.FcgiRequest{
+			ScriptFilename: "/fast.php",
+			Timeout:        time.Second,
+		})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", client.Network, err.Error())
+		} else if want, have := "done", string(resp.Body); want != have {
+			t.Errorf("%s: expected %#v, got %#v", client.Network, want, have)
+		}
+
+		_, err = client.Do(gophpfpm.FcgiRequest{
+			ScriptFilename: "/slow.php",
+			Timeout:        20 * time.Millisecond,
+		})
+		var timeoutErr *gophpfpm.FcgiTimeoutError
+		if !errors.As(err, &timeoutErr) {
+			t.Errorf("%s: expected FcgiTimeoutError, got %#v", client.Network, err)
+			continue
+		}
+		if want, have := 20*time.Millisecond, timeoutErr.Timeout; want != have {
+			t.Errorf("%s: expected %#v, got %#v", client.Network, want, have)
+		}
+	}
+}
+
+func TestFcgiClient_ExecContext(t *testing.T) {
+	release := make(chan struct{})
+	l := serveFcgi(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fcgi.ProcessEnv(r)["SCRIPT_FILENAME"] == "/slow.php" {
+			<-release
+		}
+		fmt.Fprint(w, "done")
+	}))
+	defer l.Close()
+	defer close(release)
+
+	process := &gophpfpm.Process{}
+	process.Listen = l.Addr().String()
+	client := process.Client()
+
+	body, err := client.ExecContext(context.Background(), "/fast.php", nil)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	} else if want, have := "done", string(body); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.ExecContext(ctx, "/slow.php", nil); err != context.DeadlineExceeded {
+		t.Errorf("expected %#v, got %#v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected to be cancelled in 20ms, took %s", elapsed)
+	}
+
+	// a cancelled context fails without connecting
+	if _, err := client.ExecContext(ctx, "/fast.php", nil); err != context.DeadlineExceeded {
+		t.Errorf("expected %#v, got %#v", context.DeadlineExceeded, err)
+	}
+}
@@ -0,0 +1,160 @@
+package gophpfpm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logTimeLayout is the time layout of php-fpm log lines
+const logTimeLayout = "02-Jan-2006 15:04:05"
+
+var reLogLine = regexp.MustCompile("^\\[([^\\]]+)\\] ([A-Z]+): (.*)$")
+
+// ParseLogLine parses a line logged by php-fpm. For example:
+//   [25-Dec-2023 10:00:00] NOTICE: fpm is running, pid 12345
+//
+// The timestamp is parsed in local time, as php-fpm logs it.
+// ok is false if the line is not in php-fpm log format.
+func ParseLogLine(line string) (timestamp time.Time, level string, message string, ok bool) {
+	matches := reLogLine.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+	if matches == nil {
+		return
+	}
+	timestamp, err := time.ParseInLocation(logTimeLayout, matches[1], time.Local)
+	if err != nil {
+		return
+	}
+	return timestamp, matches[2], matches[3], true
+}
+
+// ScanLogs reads php-fpm log lines from the reader (e.g. the
+// stderr returned by Start) and calls fn with the level and
+// message of every line in php-fpm log format. It returns
+// when the reader reaches EOF or fails.
+func ScanLogs(r io.Reader, fn func(level, message string)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if _, level, message, ok := ParseLogLine(scanner.Text()); ok {
+			fn(level, message)
+		}
+	}
+	return scanner.Err()
+}
+
+// logWatcher is an io.Writer that parses the php-fpm
+// log lines written to it. The ready channel is closed
+// once php-fpm reports it is running.
+type logWatcher struct {
+	buf   []byte
+	ready chan struct{}
+	once  sync.Once
+}
+
+func newLogWatcher() *logWatcher {
+	return &logWatcher{
+		ready: make(chan struct{}),
+	}
+}
+
+// Write implements io.Writer
+func (w *logWatcher) Write(p []byte) (n int, err error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(w.buf[:i])
+		w.buf = w.buf[i+1:]
+		if _, _, message, ok := ParseLogLine(line); ok && strings.HasPrefix(message, "fpm is running") {
+			w.once.Do(func() {
+				close(w.ready)
+			})
+		}
+	}
+	return len(p), nil
+}
+
+// lineTail is an io.Writer that keeps the last
+// lines written to it. The done channel is closed
+// once it is closed.
+type lineTail struct {
+	mu    sync.Mutex
+	max   int
+	buf   []byte
+	lines []string
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newLineTail(max int) *lineTail {
+	return &lineTail{
+		max:  max,
+		done: make(chan struct{}),
+	}
+}
+
+// Write implements io.Writer
+func (t *lineTail) Write(p []byte) (n int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	for {
+		i := bytes.IndexByte(t.buf, '\n')
+		if i < 0 {
+			break
+		}
+		t.lines = append(t.lines, strings.TrimRight(string(t.buf[:i]), "\r"))
+		t.buf = t.buf[i+1:]
+		if len(t.lines) > t.max {
+			t.lines = t.lines[len(t.lines)-t.max:]
+		}
+	}
+	return len(p), nil
+}
+
+// String returns the kept lines, along with the
+// last line if it is not yet terminated
+func (t *lineTail) String() string {
+	return strings.Join(t.Lines(0), "\n")
+}
+
+// Lines returns a copy of the last n kept lines, along
+// with the last line if it is not yet terminated. All
+// the kept lines are returned if n <= 0.
+func (t *lineTail) Lines(n int) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lines := append([]string(nil), t.lines...)
+	if len(t.buf) > 0 {
+		lines = append(lines, string(t.buf))
+	}
+	if n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// Close implements io.Closer
+func (t *lineTail) Close() error {
+	t.once.Do(func() {
+		close(t.done)
+	})
+	return nil
+}
+
+// appendTo returns an error of the message, with
+// the kept lines appended if any
+func (t *lineTail) appendTo(message string) error {
+	if output := t.String(); output != "" {
+		return fmt.Errorf("%s, stderr:\n%s", message, output)
+	}
+	return errors.New(message)
+}
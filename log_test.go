@@ -0,0 +1,75 @@
+package gophpfpm_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yookoala/gophpfpm"
+)
+
+func TestParseLogLine(t *testing.T) {
+	timestamp, level, message, ok := gophpfpm.ParseLogLine(
+		"[25-Dec-2023 10:00:00] NOTICE: fpm is running, pid 12345\n")
+	if !ok {
+		t.Errorf("expected ok, got %#v", ok)
+		return
+	}
+	if want, have := "2023-12-25 10:00:00", timestamp.Format("2006-01-02 15:04:05"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "NOTICE", level; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "fpm is running, pid 12345", message; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// log with microseconds
+	timestamp, level, message, ok = gophpfpm.ParseLogLine(
+		"[25-Dec-2023 10:00:00.123456] DEBUG: pid 1, fpm_scoreboard_init(), line 42: hello")
+	if !ok {
+		t.Errorf("expected ok, got %#v", ok)
+		return
+	}
+	if want, have := 123456000, timestamp.Nanosecond(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "DEBUG", level; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	for _, line := range []string{
+		"",
+		"hello world",
+		"[not a date] NOTICE: hello",
+		"[25-Dec-2023 10:00:00] hello",
+	} {
+		if _, _, _, ok := gophpfpm.ParseLogLine(line); ok {
+			t.Errorf("expected %#v not ok", line)
+		}
+	}
+}
+
+func TestScanLogs(t *testing.T) {
+	input := strings.NewReader(`[25-Dec-2023 10:00:00] NOTICE: fpm is running, pid 12345
+[25-Dec-2023 10:00:00] NOTICE: ready to handle connections
+some garbage
+[25-Dec-2023 10:00:01] WARNING: [pool www] server reached pm.max_children setting (5)
+`)
+
+	var logs []string
+	err := gophpfpm.ScanLogs(input, func(level, message string) {
+		logs = append(logs, level+": "+message)
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if want, have := []string{
+		"NOTICE: fpm is running, pid 12345",
+		"NOTICE: ready to handle connections",
+		"WARNING: [pool www] server reached pm.max_children setting (5)",
+	}, logs; !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,76 @@
+package gophpfpm
+
+// Option configures a process created by NewProcessWithOptions
+type Option func(*Process)
+
+// NewProcessWithOptions creates a new process descriptor like
+// NewProcess, then applies the options in the given order.
+// Attributes not set by the options keep the defaults of
+// NewProcess and of the generated config.
+func NewProcessWithOptions(phpFpm string, opts ...Option) *Process {
+	proc := NewProcess(phpFpm)
+	for _, opt := range opts {
+		opt(proc)
+	}
+	return proc
+}
+
+// WithListen returns an Option that sets Listen
+func WithListen(listen string) Option {
+	return func(proc *Process) {
+		proc.WithListen(listen)
+	}
+}
+
+// WithDatadir returns an Option that sets the datadir
+// like the WithDatadir method does
+func WithDatadir(prefix string) Option {
+	return func(proc *Process) {
+		proc.WithDatadir(prefix)
+	}
+}
+
+// WithPoolName returns an Option that sets PoolName
+func WithPoolName(name string) Option {
+	return func(proc *Process) {
+		proc.PoolName = name
+	}
+}
+
+// WithProcessManager returns an Option that sets
+// ProcessManager (PMStatic, PMDynamic or PMOndemand)
+func WithProcessManager(pm string) Option {
+	return func(proc *Process) {
+		proc.ProcessManager = pm
+	}
+}
+
+// WithListen sets Listen and returns the process
+func (proc *Process) WithListen(listen string) *Process {
+	proc.Listen = listen
+	return proc
+}
+
+// WithDatadir sets the datadir with SetDatadir and returns
+// the process. If the datadir is invalid, the error is
+// returned by Validate (and so Config, SaveConfig and Start)
+// until a valid datadir is set.
+func (proc *Process) WithDatadir(prefix string) *Process {
+	proc.datadirErr = proc.SetDatadir(prefix)
+	return proc
+}
+
+// WithMaxChildren sets MaxChildren and returns the process
+func (proc *Process) WithMaxChildren(maxChildren int) *Process {
+	proc.MaxChildren = maxChildren
+	return proc
+}
+
+// WithUser sets User and Group and returns the process.
+// An empty group leaves php-fpm to use the default group
+// of the user.
+func (proc *Process) WithUser(user, group string) *Process {
+	proc.User = user
+	proc.Group = group
+	return proc
+}
@@ -0,0 +1,98 @@
+package gophpfpm_test
+
+import (
+	"testing"
+
+	"github.com/yookoala/gophpfpm"
+)
+
+func TestProcess_With(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm).
+		WithDatadir(basepath+"/var").
+		WithListen("127.0.0.1:9000").
+		WithMaxChildren(20).
+		WithUser("nobody", "nogroup")
+	if want, have := basepath+"/var/phpfpm.pid", process.PidFile; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "127.0.0.1:9000", process.Listen; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 20, process.MaxChildren; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "nobody", process.User; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "nogroup", process.Group; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if err := process.Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestProcess_WithDatadirInvalid(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm).
+		WithDatadir(basepath + "/not-exists")
+	process.PidFile = basepath + "/var/phpfpm.pid"
+	process.ErrorLog = basepath + "/var/phpfpm.error_log"
+	process.Listen = "127.0.0.1:9000"
+	if err := process.Validate(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if _, err := process.Config(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	// a valid datadir replaces the error
+	process.WithDatadir(basepath + "/var")
+	if err := process.Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	// so does SetDatadir, also in the clones
+	process.WithDatadir(basepath + "/not-exists")
+	clone := process.Clone()
+	if err := process.SetDatadir(basepath + "/var"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := process.Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := clone.SetDatadir(basepath + "/var"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := clone.Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestNewProcessWithOptions(t *testing.T) {
+	process := gophpfpm.NewProcessWithOptions(pathToPhpFpm,
+		gophpfpm.WithDatadir(basepath+"/var"),
+		gophpfpm.WithListen("127.0.0.1:9000"),
+		gophpfpm.WithPoolName("tenant"),
+		gophpfpm.WithProcessManager(gophpfpm.PMStatic),
+	)
+	if want, have := pathToPhpFpm, process.Exec; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := basepath+"/var/phpfpm.pid", process.PidFile; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "127.0.0.1:9000", process.Listen; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "tenant", process.PoolName; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := gophpfpm.PMStatic, process.ProcessManager; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// defaults of NewProcess
+	if want, have := true, process.ConfigComments; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,58 @@
+// Package phpfpmtest provides helpers for testing against a
+// php-fpm process managed by gophpfpm.
+package phpfpmtest
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"testing"
+
+	"github.com/yookoala/gophpfpm"
+)
+
+// StartForTest starts php-fpm with the given executable
+// and a config generated in a temporary datadir. The test
+// fails immediately if php-fpm cannot be started. The output
+// of php-fpm is discarded.
+//
+// The returned cleanup func stops php-fpm, waits for it to
+// exit and removes the temporary datadir. It is usually
+// deferred right after the call.
+func StartForTest(t testing.TB, exec string) (*gophpfpm.Process, func()) {
+	t.Helper()
+
+	datadir, err := ioutil.TempDir("", "phpfpmtest-")
+	if err != nil {
+		t.Fatalf("unable to create datadir: %s", err)
+	}
+
+	process := gophpfpm.NewProcess(exec)
+	if err := process.SetDatadir(datadir); err != nil {
+		os.RemoveAll(datadir)
+		t.Fatalf("unable to set datadir: %s", err)
+	}
+	process.StdoutTo = ioutil.Discard
+	process.StderrTo = ioutil.Discard
+	if u, err := user.Current(); err == nil {
+		process.User = u.Username
+	}
+	process.AllowRunAsRoot = os.Geteuid() == 0
+	if err := process.SaveConfig(); err != nil {
+		os.RemoveAll(datadir)
+		t.Fatalf("unable to save config: %s", err)
+	}
+	if _, _, err := process.Start(); err != nil {
+		os.RemoveAll(datadir)
+		t.Fatalf("unable to start php-fpm: %s", err)
+	}
+
+	return process, func() {
+		t.Helper()
+		if err := process.Stop(); err != nil {
+			t.Errorf("unable to stop php-fpm: %s", err)
+		}
+		process.Wait()
+		os.RemoveAll(datadir)
+	}
+}
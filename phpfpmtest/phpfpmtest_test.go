@@ -0,0 +1,36 @@
+package phpfpmtest_test
+
+import (
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/yookoala/gophpfpm/phpfpmtest"
+)
+
+func TestStartForTest(t *testing.T) {
+	pathToPhpFpm := "/usr/sbin/php5-fpm"
+	if envPath := os.Getenv("PHPFPM_PATH"); envPath != "" {
+		pathToPhpFpm = envPath
+	}
+
+	process, cleanup := phpfpmtest.StartForTest(t, pathToPhpFpm)
+	datadir := path.Dir(process.ConfigFile)
+
+	network, address := process.ListenAddr()
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	} else {
+		conn.Close()
+	}
+
+	cleanup()
+	if process.IsRunning() {
+		t.Errorf("expected process to be stopped")
+	}
+	if _, err := os.Stat(datadir); !os.IsNotExist(err) {
+		t.Errorf("expected datadir to be removed, got %#v", err)
+	}
+}
@@ -0,0 +1,633 @@
+package gophpfpm
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+// Pool describes a php-fpm pool, which is written
+// as the [PoolName] section of the config
+type Pool struct {
+	// name of the pool section in the config.
+	// Defaults to "www" if not set
+	PoolName string
+
+	// username of the FastCGI process
+	User string
+
+	// group of the FastCGI process. If not set, php-fpm
+	// uses the default group of User
+	Group string
+
+	// The address on which to accept FastCGI requests.
+	// Valid syntaxes are: 'ip.add.re.ss:port', 'port',
+	// '/path/to/unix/socket'. This option is mandatory for each pool.
+	//
+	// A tcp port 0 (e.g. '127.0.0.1:0' or '0') is replaced with
+	// a free port when the config is generated. See ListenAddr.
+	Listen string
+
+	// the port 0 Listen value that a free port is picked for,
+	// and the listen value with the free port
+	freePortFor    string
+	freePortListen string
+
+	// Owner, group and mode of the unix socket (listen.owner,
+	// listen.group and listen.mode). Ignored for tcp listen
+	// addresses.
+	ListenOwner string
+	ListenGroup string
+	ListenMode  string
+
+	// IP addresses of the FastCGI clients allowed to connect
+	// (listen.allowed_clients). Ignored for unix socket listen
+	// addresses. Any client is allowed if empty.
+	AllowedClients []string
+
+	// Backlog of the listening socket (listen.backlog). Raise
+	// it if the clients fail under connection bursts. -1 lets
+	// the system use its maximum (net.core.somaxconn on Linux).
+	// Uses php-fpm's default if not set.
+	ListenBacklog int
+
+	// Choose how the process manager will control the number
+	// of child processes (pm). Possible values are PMDynamic,
+	// PMStatic and PMOndemand. Defaults to PMDynamic if not set.
+	ProcessManager string
+
+	// The maximum number of child processes (pm.max_children).
+	// Defaults to 5 if not set.
+	MaxChildren int
+
+	// The number of child processes created on startup
+	// (pm.start_servers). Defaults to 2 if not set.
+	StartServers int
+
+	// The desired minimum number of idle server processes
+	// (pm.min_spare_servers). Defaults to 1 if not set.
+	MinSpareServers int
+
+	// The desired maximum number of idle server processes
+	// (pm.max_spare_servers). Defaults to 3 if not set.
+	MaxSpareServers int
+
+	// Idle time after which a child process is killed
+	// (pm.process_idle_timeout). Only written for PMOndemand
+	// and PMDynamic. Defaults to 10 seconds for PMOndemand
+	// if not set
+	ProcessIdleTimeout time.Duration
+
+	// The number of requests each child process serves
+	// before being respawned (pm.max_requests). Useful to
+	// work around memory leaks. Never respawned if not set
+	MaxRequests int
+
+	// URI to view the FastCGI status page (pm.status_path).
+	// The status page is disabled if not set
+	StatusPath string
+
+	// URI to ping the FastCGI pool (ping.path) for health
+	// check. The ping page is disabled if not set
+	PingPath string
+
+	// Expected response of the ping page (ping.response).
+	// Defaults to "pong" if not set
+	PingResponse string
+
+	// Timeout for serving a single request, after which the
+	// worker is killed (request_terminate_timeout). Not
+	// limited if not set
+	RequestTerminateTimeout time.Duration
+
+	// Timeout for serving a single request, after which a PHP
+	// backtrace is dumped to SlowLog (request_slowlog_timeout).
+	// SlowLog must be set along with it
+	RequestSlowlogTimeout time.Duration
+
+	// path of the log file for slow requests (slowlog)
+	SlowLog string
+
+	// path of the access log (access.log). Access
+	// logging is disabled if not set
+	AccessLog string
+
+	// format of the access log (access.format). Uses
+	// php-fpm's default format if not set
+	AccessFormat string
+
+	// Redirect the stdout and stderr of workers to ErrorLog
+	// (catch_workers_output). Otherwise php-fpm discards
+	// them
+	CatchWorkersOutput bool
+
+	// Directory to chroot the workers to (chroot). Not
+	// chrooted if not set
+	Chroot string
+
+	// Working directory of the workers (chdir). If Chroot
+	// is also set, php-fpm resolves it inside the chroot
+	Chdir string
+
+	// Extensions of the scripts the workers are allowed to
+	// execute (security.limit_extensions), e.g. ".php" and
+	// ".phtml". Not written if empty, so php-fpm keeps its
+	// secure default of ".php" only
+	LimitExtensions []string
+
+	// Let the workers inherit the environment of php-fpm
+	// (clear_env = no). By default php-fpm clears it, so
+	// that only the variables in Env reach the scripts
+	KeepEnv bool
+
+	// Open file descriptor limit (rlimit_files) and core
+	// dump size limit (rlimit_core) of the workers. A
+	// RlimitCore of -1 is written as "unlimited". Uses the
+	// limits inherited from php-fpm if not set
+	RlimitFiles int
+	RlimitCore  int
+
+	// Environment variables passed to the scripts,
+	// written as env[NAME] = value in the pool section
+	Env map[string]string
+
+	// PHP ini settings of the pool, written as
+	// php_admin_value[name] = value. Unlike PHPValue,
+	// they cannot be overridden by ini_set() in scripts.
+	PHPAdminValue map[string]string
+
+	// PHP ini settings of the pool, written as
+	// php_value[name] = value. Scripts may still
+	// override them with ini_set().
+	PHPValue map[string]string
+
+	// Additional directives of the pool section. They are
+	// written after the generated ones and override them.
+	ExtraPoolConfig map[string]string
+}
+
+// NewPool creates a new pool descriptor
+// with the given name and listen address
+func NewPool(name, listen string) Pool {
+	return Pool{
+		PoolName: name,
+		Listen:   listen,
+	}
+}
+
+// clone returns a copy of the pool with its own maps and
+// slices. The free port picked for a port 0 Listen is not
+// kept, so the copy picks its own.
+func (pool *Pool) clone() Pool {
+	clone := *pool
+	clone.freePortFor = ""
+	clone.freePortListen = ""
+	clone.AllowedClients = copyStrings(pool.AllowedClients)
+	clone.LimitExtensions = copyStrings(pool.LimitExtensions)
+	clone.Env = copyMap(pool.Env)
+	clone.PHPAdminValue = copyMap(pool.PHPAdminValue)
+	clone.PHPValue = copyMap(pool.PHPValue)
+	clone.ExtraPoolConfig = copyMap(pool.ExtraPoolConfig)
+	return clone
+}
+
+// name returns the section name of the pool
+func (pool *Pool) name() string {
+	if pool.PoolName == "" {
+		return "www"
+	}
+	return pool.PoolName
+}
+
+// validate checks the pool attributes for values
+// that php-fpm would refuse to start with
+func (pool *Pool) validate() error {
+	if pool.Listen == "" {
+		return fmt.Errorf("listen address is not set")
+	}
+	if strings.Trim(pool.Listen, "0123456789") == "" && !isPort(pool.Listen) {
+		return fmt.Errorf("listen port (%s) must be <= 65535", pool.Listen)
+	}
+
+	if pool.ListenBacklog < -1 {
+		return fmt.Errorf("listen.backlog (%d) must be >= -1", pool.ListenBacklog)
+	}
+
+	if pool.RlimitCore < -1 {
+		return fmt.Errorf("rlimit_core (%d) must be >= -1", pool.RlimitCore)
+	}
+
+	if err := checkIniValue("access.format", pool.AccessFormat); err != nil {
+		return err
+	}
+	if err := checkIniValues("env", pool.Env); err != nil {
+		return err
+	}
+	if err := checkIniValues("php_admin_value", pool.PHPAdminValue); err != nil {
+		return err
+	}
+	if err := checkIniValues("php_value", pool.PHPValue); err != nil {
+		return err
+	}
+	if err := checkExtraConfig(pool.ExtraPoolConfig); err != nil {
+		return err
+	}
+
+	if pool.RequestSlowlogTimeout > 0 && pool.SlowLog == "" {
+		return fmt.Errorf("slow log must be set for request_slowlog_timeout")
+	}
+
+	maxChildren := intOrDefault(pool.MaxChildren, 5)
+	if maxChildren <= 0 {
+		return fmt.Errorf("pm.max_children (%d) must be > 0", maxChildren)
+	}
+
+	switch pool.ProcessManager {
+	case "", PMDynamic:
+		startServers := intOrDefault(pool.StartServers, 2)
+		minSpareServers := intOrDefault(pool.MinSpareServers, 1)
+		maxSpareServers := intOrDefault(pool.MaxSpareServers, 3)
+		if minSpareServers <= 0 {
+			return fmt.Errorf("pm.min_spare_servers (%d) must be > 0", minSpareServers)
+		}
+		if minSpareServers > maxSpareServers {
+			return fmt.Errorf("pm.min_spare_servers (%d) must be <= pm.max_spare_servers (%d)",
+				minSpareServers, maxSpareServers)
+		}
+		if maxSpareServers > maxChildren {
+			return fmt.Errorf("pm.max_spare_servers (%d) must be <= pm.max_children (%d)",
+				maxSpareServers, maxChildren)
+		}
+		if startServers < minSpareServers || startServers > maxSpareServers {
+			return fmt.Errorf("pm.start_servers (%d) must be between pm.min_spare_servers (%d) and pm.max_spare_servers (%d)",
+				startServers, minSpareServers, maxSpareServers)
+		}
+	case PMStatic, PMOndemand:
+		// only pm.max_children is checked
+	default:
+		return fmt.Errorf("unsupported process manager %#v", pool.ProcessManager)
+	}
+	return nil
+}
+
+// writeSection writes the pool as a section of f
+func (pool *Pool) writeSection(f *ini.File) {
+	pm := pool.ProcessManager
+	if pm == "" {
+		pm = PMDynamic
+	}
+
+	section, _ := f.NewSection(pool.name())
+	section.NewKey("listen", pool.listen())
+	switch network, _ := pool.Address(); network {
+	case "tcp":
+		if len(pool.AllowedClients) > 0 {
+			section.NewKey("listen.allowed_clients",
+				strings.Join(pool.AllowedClients, ","))
+		}
+	case "unix":
+		if pool.ListenOwner != "" {
+			section.NewKey("listen.owner", pool.ListenOwner)
+		}
+		if pool.ListenGroup != "" {
+			section.NewKey("listen.group", pool.ListenGroup)
+		}
+		if pool.ListenMode != "" {
+			section.NewKey("listen.mode", pool.ListenMode)
+		}
+	}
+	if pool.ListenBacklog != 0 {
+		section.NewKey("listen.backlog", strconv.Itoa(pool.ListenBacklog))
+	}
+	section.NewKey("pm", pm)
+	section.NewKey("pm.max_children",
+		strconv.Itoa(intOrDefault(pool.MaxChildren, 5)))
+	switch pm {
+	case PMDynamic:
+		section.NewKey("pm.start_servers",
+			strconv.Itoa(intOrDefault(pool.StartServers, 2)))
+		section.NewKey("pm.min_spare_servers",
+			strconv.Itoa(intOrDefault(pool.MinSpareServers, 1)))
+		section.NewKey("pm.max_spare_servers",
+			strconv.Itoa(intOrDefault(pool.MaxSpareServers, 3)))
+		if pool.ProcessIdleTimeout > 0 {
+			section.NewKey("pm.process_idle_timeout",
+				fpmDuration(pool.ProcessIdleTimeout))
+		}
+	case PMStatic:
+		// only pm.max_children is used
+	case PMOndemand:
+		idleTimeout := pool.ProcessIdleTimeout
+		if idleTimeout == 0 {
+			idleTimeout = time.Second * 10
+		}
+		section.NewKey("pm.process_idle_timeout", fpmDuration(idleTimeout))
+	}
+	if pool.MaxRequests > 0 {
+		section.NewKey("pm.max_requests", strconv.Itoa(pool.MaxRequests))
+	}
+	if pool.User != "" {
+		section.NewKey("user", pool.User)
+	}
+	if pool.Group != "" {
+		section.NewKey("group", pool.Group)
+	}
+	if pool.StatusPath != "" {
+		section.NewKey("pm.status_path", pool.StatusPath)
+	}
+	if pool.PingPath != "" {
+		section.NewKey("ping.path", pool.PingPath)
+	}
+	if pool.PingResponse != "" {
+		section.NewKey("ping.response", pool.PingResponse)
+	}
+	if pool.RequestTerminateTimeout > 0 {
+		section.NewKey("request_terminate_timeout",
+			fpmDuration(pool.RequestTerminateTimeout))
+	}
+	if pool.RequestSlowlogTimeout > 0 {
+		section.NewKey("request_slowlog_timeout",
+			fpmDuration(pool.RequestSlowlogTimeout))
+	}
+	if pool.SlowLog != "" {
+		section.NewKey("slowlog", pool.SlowLog)
+	}
+	if pool.AccessLog != "" {
+		section.NewKey("access.log", pool.AccessLog)
+	}
+	if pool.AccessFormat != "" {
+		section.NewKey("access.format", iniValue(pool.AccessFormat))
+	}
+	if pool.CatchWorkersOutput {
+		section.NewKey("catch_workers_output", "yes")
+	}
+	if pool.KeepEnv {
+		section.NewKey("clear_env", "no")
+	}
+	if pool.Chroot != "" {
+		section.NewKey("chroot", pool.Chroot)
+	}
+	if pool.Chdir != "" {
+		section.NewKey("chdir", pool.Chdir)
+	}
+	if len(pool.LimitExtensions) > 0 {
+		section.NewKey("security.limit_extensions",
+			strings.Join(pool.LimitExtensions, " "))
+	}
+	if pool.RlimitFiles > 0 {
+		section.NewKey("rlimit_files", strconv.Itoa(pool.RlimitFiles))
+	}
+	if pool.RlimitCore == -1 {
+		section.NewKey("rlimit_core", "unlimited")
+	} else if pool.RlimitCore > 0 {
+		section.NewKey("rlimit_core", strconv.Itoa(pool.RlimitCore))
+	}
+	setArrayKeys(section, "env", pool.Env)
+	setArrayKeys(section, "php_admin_value", pool.PHPAdminValue)
+	setArrayKeys(section, "php_value", pool.PHPValue)
+	setKeys(section, pool.ExtraPoolConfig)
+}
+
+// loadSection sets the pool attributes according to
+// the section. Keys that are not modeled by Pool are
+// kept in ExtraPoolConfig.
+func (pool *Pool) loadSection(section *ini.Section) (err error) {
+	env := make(map[string]string)
+	phpAdminValue := make(map[string]string)
+	phpValue := make(map[string]string)
+	extraPool := make(map[string]string)
+	for _, key := range section.Keys() {
+		key.SetValue(iniUnquote(key.Value()))
+		if prefix, name, ok := arrayKey(key.Name()); ok {
+			switch prefix {
+			case "env":
+				env[name] = key.String()
+				continue
+			case "php_admin_value":
+				phpAdminValue[name] = key.String()
+				continue
+			case "php_value":
+				phpValue[name] = key.String()
+				continue
+			}
+		}
+
+		var target *int
+		var durationTarget *time.Duration
+		var boolTarget *bool
+		switch key.Name() {
+		case "listen":
+			pool.Listen = key.String()
+		case "listen.owner":
+			pool.ListenOwner = key.String()
+		case "listen.group":
+			pool.ListenGroup = key.String()
+		case "listen.mode":
+			pool.ListenMode = key.String()
+		case "listen.allowed_clients":
+			pool.AllowedClients = key.Strings(",")
+		case "listen.backlog":
+			target = &pool.ListenBacklog
+		case "user":
+			pool.User = key.String()
+		case "group":
+			pool.Group = key.String()
+		case "pm.status_path":
+			pool.StatusPath = key.String()
+		case "ping.path":
+			pool.PingPath = key.String()
+		case "ping.response":
+			pool.PingResponse = key.String()
+		case "request_terminate_timeout":
+			durationTarget = &pool.RequestTerminateTimeout
+		case "request_slowlog_timeout":
+			durationTarget = &pool.RequestSlowlogTimeout
+		case "slowlog":
+			pool.SlowLog = key.String()
+		case "access.log":
+			pool.AccessLog = key.String()
+		case "access.format":
+			pool.AccessFormat = key.String()
+		case "catch_workers_output":
+			boolTarget = &pool.CatchWorkersOutput
+		case "clear_env":
+			var clearEnv bool
+			if clearEnv, err = key.Bool(); err != nil {
+				return fmt.Errorf("invalid value %#v for %s", key.String(), key.Name())
+			}
+			pool.KeepEnv = !clearEnv
+		case "chroot":
+			pool.Chroot = key.String()
+		case "chdir":
+			pool.Chdir = key.String()
+		case "security.limit_extensions":
+			pool.LimitExtensions = strings.Fields(key.String())
+		case "rlimit_files":
+			target = &pool.RlimitFiles
+		case "rlimit_core":
+			if key.String() == "unlimited" {
+				pool.RlimitCore = -1
+			} else {
+				target = &pool.RlimitCore
+			}
+		case "pm":
+			pool.ProcessManager = key.String()
+		case "pm.max_children":
+			target = &pool.MaxChildren
+		case "pm.start_servers":
+			target = &pool.StartServers
+		case "pm.min_spare_servers":
+			target = &pool.MinSpareServers
+		case "pm.max_spare_servers":
+			target = &pool.MaxSpareServers
+		case "pm.max_requests":
+			target = &pool.MaxRequests
+		case "pm.process_idle_timeout":
+			durationTarget = &pool.ProcessIdleTimeout
+		default:
+			extraPool[key.Name()] = key.String()
+		}
+		if target != nil {
+			if *target, err = key.Int(); err != nil {
+				return fmt.Errorf("invalid value %#v for %s", key.String(), key.Name())
+			}
+		}
+		if durationTarget != nil {
+			if *durationTarget, err = parseFpmDuration(key.String()); err != nil {
+				return fmt.Errorf("invalid value %#v for %s", key.String(), key.Name())
+			}
+		}
+		if boolTarget != nil {
+			if *boolTarget, err = key.Bool(); err != nil {
+				return fmt.Errorf("invalid value %#v for %s", key.String(), key.Name())
+			}
+		}
+	}
+
+	pool.PoolName = section.Name()
+	pool.Env = env
+	pool.PHPAdminValue = phpAdminValue
+	pool.PHPValue = phpValue
+	pool.ExtraPoolConfig = extraPool
+	return
+}
+
+// Address returns networkk and address that fits
+// the use of either net.Dial or net.Listen
+//
+// Listen is classified as follows:
+//   - a value containing "/" or ending with ".sock" is a
+//     unix socket path (e.g. "/path/to/hello.sock")
+//   - a port number alone is a tcp port on all
+//     interfaces (e.g. "9000")
+//   - "host:port" with a numeric port is a tcp address
+//     (e.g. "127.0.0.1:9000", "localhost:9000",
+//     "[::1]:9000")
+//   - anything else is a unix socket path
+//     (e.g. "myhost", "hello")
+//
+// If a free port is picked for a port 0 Listen, the address
+// has the free port.
+func (pool *Pool) Address() (network, address string) {
+	return listenAddress(pool.listen())
+}
+
+// listenAddress classifies the listen value. See Address
+func listenAddress(listen string) (network, address string) {
+	switch {
+	case strings.Contains(listen, "/"), strings.HasSuffix(listen, ".sock"):
+		network = "unix"
+		address = listen
+	case isPort(listen):
+		network = "tcp"
+		address = ":" + listen
+	case isHostPort(listen):
+		network = "tcp"
+		address = listen
+	default:
+		network = "unix"
+		address = listen
+	}
+	return
+}
+
+// listen returns the listen value written to the config,
+// which has the free port picked for a port 0 Listen
+func (pool *Pool) listen() string {
+	if pool.freePortFor != "" && pool.freePortFor == pool.Listen {
+		return pool.freePortListen
+	}
+	return pool.Listen
+}
+
+// pickFreePort picks a free port for a tcp Listen with port 0
+// by binding a listener to the address and closing it. The
+// port is kept until Listen is changed.
+func (pool *Pool) pickFreePort() error {
+	if pool.freePortFor != "" && pool.freePortFor == pool.Listen {
+		return nil
+	}
+	network, address := listenAddress(pool.Listen)
+	if network != "tcp" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || port != "0" {
+		return nil
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("unable to find a free port for %s: %s", pool.Listen, err)
+	}
+	_, port, _ = net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	pool.freePortFor = pool.Listen
+	if host == "" {
+		pool.freePortListen = port
+	} else {
+		pool.freePortListen = net.JoinHostPort(host, port)
+	}
+	return nil
+}
+
+// ListenAddr returns the network and address php-fpm binds
+// for Listen, with the free port picked for a port 0 and
+// the host of a tcp address resolved to
+// an IP address (e.g. "localhost:9000" to "127.0.0.1:9000").
+//
+// If Listen is a port alone, php-fpm binds to all interfaces
+// and the address is a wildcard bind without a host
+// (e.g. ":9000"). A host that cannot be resolved is returned
+// as is.
+func (pool *Pool) ListenAddr() (network, address string) {
+	network, address = pool.Address()
+	if network != "tcp" {
+		return
+	}
+	if addr, err := net.ResolveTCPAddr(network, address); err == nil {
+		address = addr.String()
+	}
+	return
+}
+
+// isPort checks if the value is a port number
+// (0 to 65535)
+func isPort(value string) bool {
+	_, err := strconv.ParseUint(value, 10, 16)
+	return err == nil
+}
+
+// isHostPort checks if the value is in the form of
+// "host:port" with a numeric port
+func isHostPort(value string) bool {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil || host == "" {
+		return false
+	}
+	return isPort(port)
+}
@@ -0,0 +1,154 @@
+package gophpfpm_test
+
+import (
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/yookoala/gophpfpm"
+)
+
+func TestNewPool(t *testing.T) {
+	pool := gophpfpm.NewPool("api", "127.0.0.1:9000")
+	if want, have := "api", pool.PoolName; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "127.0.0.1:9000", pool.Listen; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_ConfigPools(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	api := gophpfpm.NewPool("api", basepath+"/var/api.sock")
+	api.ProcessManager = gophpfpm.PMStatic
+	api.MaxChildren = 10
+	web := gophpfpm.NewPool("web", "127.0.0.1:9000")
+	web.User = "nobody"
+	process.Pools = []gophpfpm.Pool{api, web}
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := []string{"DEFAULT", "global", "www", "api", "web"}, f.SectionStrings(); !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := basepath+"/var/phpfpm.sock", f.Section("www").Key("listen").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := basepath+"/var/api.sock", f.Section("api").Key("listen").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "static", f.Section("api").Key("pm").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "10", f.Section("api").Key("pm.max_children").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "127.0.0.1:9000", f.Section("web").Key("listen").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "nobody", f.Section("web").Key("user").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if f.Section("www").HasKey("user") {
+		t.Errorf("unexpected user in www pool")
+	}
+}
+
+func TestProcess_ValidatePools(t *testing.T) {
+	invalids := map[string][]gophpfpm.Pool{
+		"duplicated name": {gophpfpm.NewPool("api", "9001"), gophpfpm.NewPool("api", "9002")},
+		"default name":    {gophpfpm.NewPool("www", "9001")},
+		"reserved name":   {gophpfpm.NewPool("global", "9001")},
+		"empty listen":    {gophpfpm.NewPool("api", "")},
+		"unknown pm":      {{PoolName: "api", Listen: "9001", ProcessManager: "foobar"}},
+		"unnamed pool":    {{Listen: "9001"}},
+	}
+	for name, pools := range invalids {
+		process := gophpfpm.NewProcess(pathToPhpFpm)
+		process.SetDatadir(basepath + "/var")
+		process.Pools = pools
+		if err := process.Validate(); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestProcess_LoadConfigPools(t *testing.T) {
+	configFile := basepath + "/etc/test.loadconfigpools.conf"
+	ioutil.WriteFile(configFile, []byte(`[global]
+pid = /tmp/hello.pid
+error_log = /tmp/hello.error_log
+
+[api]
+listen = /tmp/api.sock
+pm = static
+pm.max_children = 10
+
+[web]
+listen = 127.0.0.1:9000
+user = nobody
+`), 0644)
+
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	if err := process.LoadConfig(configFile); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "api", process.PoolName; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "/tmp/api.sock", process.Listen; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 10, process.MaxChildren; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 1, len(process.Pools); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+		return
+	}
+	if want, have := "web", process.Pools[0].PoolName; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "nobody", process.Pools[0].User; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := false, process.Pools[0].KeepEnv; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_StartPools(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.pools")
+	process.User = username
+	api := gophpfpm.NewPool("api", basepath+"/var/test.pools.api.sock")
+	api.User = username
+	process.Pools = []gophpfpm.Pool{api}
+	if err := process.SaveConfig(basepath + "/etc/test.pools.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	defer process.Wait()
+	defer process.Stop()
+
+	for _, pool := range append([]gophpfpm.Pool{process.Pool}, process.Pools...) {
+		conn, err := net.Dial(pool.Address())
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", pool.PoolName, err.Error())
+			continue
+		}
+		conn.Close()
+	}
+}
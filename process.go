@@ -1,14 +1,21 @@
 package gophpfpm
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
 	"os/exec"
 	"path"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -16,22 +23,36 @@ import (
 )
 
 // Process describes a minimalistic php-fpm config
-// that runs only 1 pool
+// that runs the embedded pool, and optionally the
+// additional Pools, under one master process.
+//
+// The lifecycle methods (Start, StartContext, Stop,
+// StopWithSignal, StopContext, Reload, ReopenLogs,
+// Signal, Wait, WaitContext, Done, Pid, IsRunning,
+// ExitCode and ExitedBySignal) are safe for concurrent
+// use. They do not wait for Start to return: Stop called
+// during Start stops the starting php-fpm, and Start then
+// returns error. The config fields must not be modified
+// while the process is started.
 type Process struct {
 
-	// path to php-fpm executable
+	// path to php-fpm executable. If it contains no path
+	// separator (e.g. "php-fpm"), it is searched in the PATH
 	Exec string
 
-	// path to the config file
+	// path to the config file. If not set, Start() saves
+	// the config to a temporary file, which is removed once
+	// the process exits
 	ConfigFile string
 
-	// username of the FastCGI process
-	User string
+	// the pool of the process. Its fields (e.g. Listen,
+	// MaxChildren) are accessible directly on Process
+	Pool
 
-	// The address on which to accept FastCGI requests.
-	// Valid syntaxes are: 'ip.add.re.ss:port', 'port',
-	// '/path/to/unix/socket'. This option is mandatory for each pool.
-	Listen string
+	// Additional pools run by the same master process.
+	// Each of them needs a unique PoolName and Listen.
+	// Start() only waits for the pool of the process
+	Pools []Pool
 
 	// path of the PID file
 	PidFile string
@@ -39,192 +60,1930 @@ type Process struct {
 	// path of the error log
 	ErrorLog string
 
+	// Verbosity of ErrorLog (log_level). Possible values are
+	// "alert", "error", "warning", "notice" and "debug".
+	// Uses php-fpm's default ("notice") if not set
+	LogLevel string
+
+	// Restart the master process gracefully if this number of
+	// child processes exit with SIGSEGV or SIGBUS within
+	// EmergencyRestartInterval (emergency_restart_threshold).
+	// Disabled if not set
+	EmergencyRestartThreshold int
+
+	// Interval used by EmergencyRestartThreshold
+	// (emergency_restart_interval)
+	EmergencyRestartInterval time.Duration
+
+	// Time limit for child processes to wait for a reaction on
+	// signals from master (process_control_timeout). Not
+	// limited if not set
+	ProcessControlTimeout time.Duration
+
+	// path to the php.ini file. If not set, php-fpm
+	// is started without any php.ini file
+	PhpIni string
+
+	// Allow php-fpm to run as root (--allow-to-run-as-root).
+	// Needed when running as uid 0, e.g. in a container
+	AllowRunAsRoot bool
+
+	// Additional command line arguments to php-fpm
+	// (e.g. "-d", "foo=bar"). They are appended to
+	// the arguments generated by Start()
+	ExtraArgs []string
+
+	// Let php-fpm daemonize itself instead of running in
+	// foreground (-F). Start() returns once the daemon is
+	// ready and the launching process has exited. Stop(),
+	// Reload(), Pid(), IsRunning() and Wait() then operate
+	// on the master process found with PidFile on start,
+	// and Wait() polls until that process is gone
+	Daemonize bool
+
+	// Keep PidFile and the unix socket after Wait(). By
+	// default they are removed once the process exits
+	// successfully
+	KeepFiles bool
+
+	// Environment of the php-fpm process itself, in the form
+	// of "key=value". If nil, php-fpm inherits the environment
+	// of the current process. Not to be confused with Env,
+	// which is passed to the scripts
+	ProcessEnv []string
+
+	// Working directory of the php-fpm process. Relative
+	// paths in the config are resolved against it. If not
+	// set, php-fpm runs in the current directory
+	WorkingDir string
+
+	// If set, the stdout and stderr of php-fpm are written to
+	// them and Start() returns nil for the matching
+	// io.ReadCloser. In foreground mode, Wait() returns once
+	// the output is all written.
+	StdoutTo io.Writer
+	StderrTo io.Writer
+
+	// Keep the last lines of the stderr of php-fpm in memory
+	// for StderrTail(). Unless StderrTo is set, the stderr is
+	// then drained by the package and Start() returns nil
+	// for its io.ReadCloser.
+	CaptureStderr bool
+
+	// How Start() decides php-fpm is ready. Possible values
+	// are ReadinessSocket and ReadinessLog. Defaults to
+	// ReadinessSocket if not set.
+	ReadinessMode string
+
+	// How long Start() waits for php-fpm to accept
+	// connections. php-fpm is killed if it is not ready
+	// by then. Defaults to 10 seconds if not set.
+	StartTimeout time.Duration
+
+	// How often Start() tries to connect php-fpm in
+	// ReadinessSocket mode. Defaults to 2 milliseconds
+	// if not set.
+	ReadinessPollInterval time.Duration
+
+	// Also wait for PidFile to have a valid pid before
+	// Start() considers php-fpm ready. For systems where
+	// the socket accepts before php-fpm handles requests
+	StrictReadiness bool
+
+	// Timeouts of the FastCGI requests sent by Client(),
+	// Status() and Ping(), to connect the pool and to
+	// exchange the request once connected. Default to
+	// 5 seconds if not set. See FcgiTimeoutError.
+	FcgiConnectTimeout time.Duration
+	FcgiReadTimeout    time.Duration
+
+	// Backoff of Supervise() before restarting an exited
+	// php-fpm. It starts with RestartBackoffInitial (1
+	// second if not set) and doubles on every restart, up
+	// to RestartBackoffMax (30 seconds if not set)
+	RestartBackoffInitial time.Duration
+	RestartBackoffMax     time.Duration
+
+	// Maximum number of restarts by Supervise(). Not
+	// limited if not set
+	MaxRestarts int
+
+	// How long Supervise() waits for php-fpm to stop
+	// gracefully once its context is done, before killing
+	// it. Defaults to 10 seconds if not set
+	StopGracePeriod time.Duration
+
+	// Called once the process exits, with its state and the
+	// error Wait() returns. It is called in a separate
+	// goroutine, once per started process. In daemon mode,
+	// the state is nil as the daemon is not a child process
+	OnExit func(state *os.ProcessState, err error)
+
+	// Additional directives of the [global] section. They are
+	// written after the generated ones and override them.
+	ExtraGlobalConfig map[string]string
+
+	// Adds comments to the generated config for people
+	// reading it: a header in front of [global] and short
+	// notes on the pm.* keys. NewProcess sets it to true.
+	ConfigComments bool
+
+	// datadirErr is the error of the last WithDatadir,
+	// returned by Validate
+	datadirErr error
+
+	// mu guards the lifecycle state below
+	mu sync.Mutex
+
 	// cmd stores the command of the running process
 	cmd *exec.Cmd
+
+	// daemon stores the master process in daemon mode
+	daemon *os.Process
+
+	// starting is set while Start waits for php-fpm
+	// to be ready, with mu released
+	starting bool
+
+	// stopped is set once the process is stopped with
+	// Stop, StopWithSignal or StopContext
+	stopped bool
+
+	// exit stores the result of waiting for the
+	// started process
+	exit *exitStatus
+
+	// tempConfig is the temporary config file
+	// saved by Start, if any
+	tempConfig string
+
+	// stderrTail keeps the stderr of the last
+	// started process if CaptureStderr is set
+	stderrTail *lineTail
+
+	// discardOutput discards the stdout and stderr not
+	// written to StdoutTo or StderrTo, instead of returning
+	// them as pipes. Set by Supervise, which has no caller
+	// to consume the pipes
+	discardOutput bool
+}
+
+// exitStatus is the result of waiting for a started process.
+// state and err are set before done is closed, and must only
+// be read after that.
+type exitStatus struct {
+	done  chan struct{}
+	state *os.ProcessState
+	err   error
+
+	// launcher is the state of the launching
+	// process in daemon mode
+	launcher *os.ProcessState
+}
+
+// ErrNotStarted is returned when operating on a
+// process that has not been started
+var ErrNotStarted = errors.New("php-fpm process is not started")
+
+// ErrAlreadyStarted is returned by Start() when the
+// process is started and has not exited yet
+var ErrAlreadyStarted = errors.New("php-fpm process is already started")
+
+// Phases of Start() reported by StartError
+const (
+	// StartPhaseCleanup is the cleanup of the files left
+	// by a previous process (see Cleanup)
+	StartPhaseCleanup = "cleanup"
+
+	// StartPhaseConfig is the generation of the temporary
+	// config file, if ConfigFile is not set
+	StartPhaseConfig = "config"
+
+	// StartPhasePipe is the creation of the pipes for
+	// the stdout and stderr of php-fpm
+	StartPhasePipe = "pipe"
+
+	// StartPhaseExec is the execution of php-fpm
+	StartPhaseExec = "exec"
+
+	// StartPhaseTimeout is the wait for php-fpm to be
+	// ready, which timed out (see StartTimeout)
+	StartPhaseTimeout = "readiness-timeout"
+
+	// StartPhaseExit is the wait for php-fpm to be
+	// ready, during which php-fpm exited
+	StartPhaseExit = "exit"
+
+	// StartPhaseDaemonize is the wait for the launching
+	// process to daemonize (see Daemonize)
+	StartPhaseDaemonize = "daemonize"
+)
+
+// StartError is returned by Start() when php-fpm fails
+// to start. Use errors.As to check the Phase it fails in
+type StartError struct {
+	// the phase of Start() that fails
+	Phase string
+
+	// the network (e.g. "unix") and address (e.g.
+	// "/path/to/phpfpm.sock") of the pool
+	Net  string
+	Addr string
+
+	// the cause of the failure
+	Err error
+}
+
+// Error implements error
+func (err *StartError) Error() string {
+	return fmt.Sprintf("unable to start php-fpm on %s %s (%s): %s",
+		err.Net, err.Addr, err.Phase, err.Err)
+}
+
+// Unwrap returns the cause of the failure
+func (err *StartError) Unwrap() error {
+	return err.Err
 }
 
+// Process managers supported by php-fpm
+const (
+	// PMDynamic keeps the number of child processes between
+	// pm.min_spare_servers and pm.max_spare_servers
+	PMDynamic = "dynamic"
+
+	// PMStatic keeps a fixed number (pm.max_children)
+	// of child processes
+	PMStatic = "static"
+
+	// PMOndemand forks child processes only when
+	// requests arrive
+	PMOndemand = "ondemand"
+)
+
+// Readiness modes of Start()
+const (
+	// ReadinessSocket waits until the listen
+	// address accepts connection
+	ReadinessSocket = "socket"
+
+	// ReadinessLog waits until php-fpm logs the
+	// "fpm is running" notice to stderr. It only
+	// works if ErrorLog points to stderr
+	// (e.g. "/proc/self/fd/2").
+	ReadinessLog = "log"
+)
+
 // NewProcess creates a new process descriptor
 func NewProcess(phpFpm string) *Process {
 	return &Process{
-		Exec: phpFpm,
+		Exec:           phpFpm,
+		ConfigComments: true,
+	}
+}
+
+// NewProcessChecked creates a new process descriptor like
+// NewProcess, but first checks that phpFpm exists and is
+// executable. If phpFpm contains no path separator, it is
+// searched in the PATH and Exec is set to the path found.
+func NewProcessChecked(phpFpm string) (*Process, error) {
+	path, err := exec.LookPath(phpFpm)
+	if err != nil {
+		return nil, fmt.Errorf("invalid php-fpm executable: %s", err)
+	}
+	return NewProcess(path), nil
+}
+
+// Clone returns a new process descriptor with the config
+// fields of the process. Maps and slices are copied, so the
+// clone can be modified without affecting the process. The
+// runtime state is not copied: the clone is not started.
+//
+// StdoutTo, StderrTo and OnExit are shared with the process.
+// A temporary ConfigFile saved by Start and the free ports
+// picked for a port 0 Listen are not kept.
+func (proc *Process) Clone() *Process {
+	clone := &Process{
+		Exec:                      proc.Exec,
+		ConfigFile:                proc.ConfigFile,
+		Pool:                      proc.Pool.clone(),
+		PidFile:                   proc.PidFile,
+		ErrorLog:                  proc.ErrorLog,
+		LogLevel:                  proc.LogLevel,
+		EmergencyRestartThreshold: proc.EmergencyRestartThreshold,
+		EmergencyRestartInterval:  proc.EmergencyRestartInterval,
+		ProcessControlTimeout:     proc.ProcessControlTimeout,
+		PhpIni:                    proc.PhpIni,
+		AllowRunAsRoot:            proc.AllowRunAsRoot,
+		ExtraArgs:                 copyStrings(proc.ExtraArgs),
+		Daemonize:                 proc.Daemonize,
+		KeepFiles:                 proc.KeepFiles,
+		ProcessEnv:                copyStrings(proc.ProcessEnv),
+		WorkingDir:                proc.WorkingDir,
+		StdoutTo:                  proc.StdoutTo,
+		StderrTo:                  proc.StderrTo,
+		CaptureStderr:             proc.CaptureStderr,
+		ReadinessMode:             proc.ReadinessMode,
+		StartTimeout:              proc.StartTimeout,
+		ReadinessPollInterval:     proc.ReadinessPollInterval,
+		StrictReadiness:           proc.StrictReadiness,
+		FcgiConnectTimeout:        proc.FcgiConnectTimeout,
+		FcgiReadTimeout:           proc.FcgiReadTimeout,
+		RestartBackoffInitial:     proc.RestartBackoffInitial,
+		RestartBackoffMax:         proc.RestartBackoffMax,
+		MaxRestarts:               proc.MaxRestarts,
+		StopGracePeriod:           proc.StopGracePeriod,
+		OnExit:                    proc.OnExit,
+		ExtraGlobalConfig:         copyMap(proc.ExtraGlobalConfig),
+		ConfigComments:            proc.ConfigComments,
+		datadirErr:                proc.datadirErr,
+	}
+	if proc.Pools != nil {
+		clone.Pools = make([]Pool, len(proc.Pools))
+		for i := range proc.Pools {
+			clone.Pools[i] = proc.Pools[i].clone()
+		}
 	}
+
+	proc.mu.Lock()
+	if proc.tempConfig != "" && clone.ConfigFile == proc.tempConfig {
+		clone.ConfigFile = ""
+	}
+	proc.mu.Unlock()
+	return clone
 }
 
 // SaveConfig generates config file according to the
-// process attributes
-func (proc *Process) SaveConfig(path string) {
+// process attributes. The file is saved to the given path,
+// which becomes ConfigFile, or to ConfigFile if no path is
+// given.
+func (proc *Process) SaveConfig(paths ...string) (err error) {
+	switch len(paths) {
+	case 0:
+		if proc.ConfigFile == "" {
+			return fmt.Errorf("config file is not set")
+		}
+	case 1:
+		proc.ConfigFile = paths[0]
+	default:
+		return fmt.Errorf("only 1 path is allowed, got %d", len(paths))
+	}
+	// render the config first, so the file is not created
+	// (or truncated) if the attributes are invalid
+	var buf bytes.Buffer
+	if _, err = proc.WriteConfigTo(&buf); err != nil {
+		return
+	}
+	return ioutil.WriteFile(proc.ConfigFile, buf.Bytes(), 0644)
+}
+
+// AppendConfig adds the pool sections of the process to
+// an existing config file, which becomes ConfigFile. The
+// sections with the same name are replaced. Other sections
+// (including [global]) and their comments are kept.
+func (proc *Process) AppendConfig(path string) (err error) {
+	if err = proc.validatePools(); err != nil {
+		return
+	}
+	f, err := ini.LoadSources(iniOptions, path)
+	if err != nil {
+		return
+	}
+	if err = proc.pickFreePorts(); err != nil {
+		return
+	}
+	for _, pool := range append([]Pool{proc.Pool}, proc.Pools...) {
+		f.DeleteSection(pool.name())
+		pool.writeSection(f)
+		if proc.ConfigComments {
+			commentPoolSection(f.Section(pool.name()))
+		}
+	}
+	if err = f.SaveTo(path); err != nil {
+		return
+	}
 	proc.ConfigFile = path
-	proc.Config().SaveTo(proc.ConfigFile)
+	return
+}
+
+// WriteConfigTo generates the config like Config does
+// and writes it to w. Returns the number of bytes written
+func (proc *Process) WriteConfigTo(w io.Writer) (int64, error) {
+	f, err := proc.Config()
+	if err != nil {
+		return 0, err
+	}
+	return f.WriteTo(w)
+}
+
+// Validate checks the process attributes for values
+// that php-fpm would refuse to start with
+func (proc *Process) Validate() error {
+	if proc.datadirErr != nil {
+		return proc.datadirErr
+	}
+	if proc.PidFile == "" {
+		return fmt.Errorf("pid file is not set")
+	}
+	if proc.ErrorLog == "" {
+		return fmt.Errorf("error log is not set")
+	}
+
+	switch proc.LogLevel {
+	case "", "alert", "error", "warning", "notice", "debug":
+		// supported
+	default:
+		return fmt.Errorf("unsupported log level %#v", proc.LogLevel)
+	}
+	if err := checkExtraConfig(proc.ExtraGlobalConfig); err != nil {
+		return err
+	}
+	return proc.validatePools()
+}
+
+// validatePools checks the pool of the process
+// and the additional Pools
+func (proc *Process) validatePools() error {
+	if err := proc.Pool.validate(); err != nil {
+		return err
+	}
+
+	// pool names share the namespace with [global]
+	names := map[string]bool{"global": true}
+	for _, pool := range append([]Pool{proc.Pool}, proc.Pools...) {
+		if names[pool.name()] {
+			return fmt.Errorf("pool name %#v is already used", pool.name())
+		}
+		names[pool.name()] = true
+	}
+	for i := range proc.Pools {
+		if err := proc.Pools[i].validate(); err != nil {
+			return fmt.Errorf("pool %#v: %s", proc.Pools[i].name(), err)
+		}
+	}
+	return nil
+}
+
+// pickFreePorts picks the free ports for the pools
+// listening on tcp port 0
+func (proc *Process) pickFreePorts() error {
+	if err := proc.Pool.pickFreePort(); err != nil {
+		return err
+	}
+	for i := range proc.Pools {
+		if err := proc.Pools[i].pickFreePort(); err != nil {
+			return fmt.Errorf("pool %#v: %s", proc.Pools[i].name(), err)
+		}
+	}
+	return nil
 }
 
 // Config generates an minimalistic config ini file
 // in *ini.File format. You may then use SaveTo(path)
 // to save it
-func (proc *Process) Config() (f *ini.File) {
-	f = ini.Empty()
+//
+// The output is stable for the same attributes. The [global]
+// section comes first, then the pool of the process and the
+// additional Pools in order. Keys of each section are written
+// in a fixed sequence, followed by the keys from maps (Env,
+// PHPAdminValue, PHPValue, then ExtraPoolConfig or
+// ExtraGlobalConfig) sorted by name.
+//
+// Returns error if the process attributes are not valid.
+// See Validate. A free port is picked for each pool that
+// listens on tcp port 0.
+func (proc *Process) Config() (f *ini.File, err error) {
+	if err = proc.Validate(); err != nil {
+		return nil, err
+	}
+
+	f, _ = ini.LoadSources(iniOptions, []byte(""))
 	f.NewSection("global")
 	f.Section("global").NewKey("pid", proc.PidFile)
 	f.Section("global").NewKey("error_log", proc.ErrorLog)
-	f.NewSection("www")
-	f.Section("www").NewKey("listen", proc.Listen)
-	f.Section("www").NewKey("pm", "dynamic")
-	f.Section("www").NewKey("pm.max_children", "5")
-	f.Section("www").NewKey("pm.start_servers", "2")
-	f.Section("www").NewKey("pm.min_spare_servers", "1")
-	f.Section("www").NewKey("pm.max_spare_servers", "3")
-	if proc.User != "" {
-		f.Section("www").NewKey("user", proc.User)
+	if proc.LogLevel != "" {
+		f.Section("global").NewKey("log_level", proc.LogLevel)
+	}
+	if proc.EmergencyRestartThreshold > 0 {
+		f.Section("global").NewKey("emergency_restart_threshold",
+			strconv.Itoa(proc.EmergencyRestartThreshold))
+	}
+	if proc.EmergencyRestartInterval > 0 {
+		f.Section("global").NewKey("emergency_restart_interval",
+			fpmDuration(proc.EmergencyRestartInterval))
+	}
+	if proc.ProcessControlTimeout > 0 {
+		f.Section("global").NewKey("process_control_timeout",
+			fpmDuration(proc.ProcessControlTimeout))
+	}
+	if err = proc.pickFreePorts(); err != nil {
+		return nil, err
+	}
+	proc.Pool.writeSection(f)
+	for i := range proc.Pools {
+		proc.Pools[i].writeSection(f)
+	}
+	setKeys(f.Section("global"), proc.ExtraGlobalConfig)
+	if proc.ConfigComments {
+		f.Section("global").Comment = configHeader
+		commentPoolSection(f.Section(proc.Pool.name()))
+		for i := range proc.Pools {
+			commentPoolSection(f.Section(proc.Pools[i].name()))
+		}
 	}
 	return
 }
 
-// SetDatadir sets default config values according
-// with reference to the folder prefix
-//
-// Equals to running these 3 statements:
-//   process.PidFile  = basepath + "/phpfpm.pid"
-//   process.ErrorLog = basepath + "/phpfpm.error_log"
-//   process.Listen   = basepath + "/phpfpm.sock"
-func (proc *Process) SetDatadir(prefix string) {
-	// FIXME: add error if the prefix folder doesn't exists
-	// or is not a folder
-	proc.PidFile = path.Join(prefix, "phpfpm.pid")
-	proc.ErrorLog = path.Join(prefix, "phpfpm.error_log")
-	proc.Listen = path.Join(prefix, "phpfpm.sock")
+// configHeader is the comment in front of the generated config
+const configHeader = "; managed by gophpfpm\n" +
+	"; changes may be overwritten when the config is generated again"
+
+// poolKeyComments are the comments of the pool keys
+// in the generated config
+var poolKeyComments = map[string]string{
+	"pm":                      "process manager: static, dynamic or ondemand",
+	"pm.max_children":         "maximum number of child processes",
+	"pm.start_servers":        "number of child processes created on startup (dynamic)",
+	"pm.min_spare_servers":    "minimum number of idle child processes (dynamic)",
+	"pm.max_spare_servers":    "maximum number of idle child processes (dynamic)",
+	"pm.process_idle_timeout": "idle time after which a child process is killed",
+	"pm.max_requests":         "requests served by a child process before it is respawned",
 }
 
-// Start starts the php-fpm process
-// in foreground mode instead of daemonize
-func (proc *Process) Start() (err error) {
-	proc.cmd = &exec.Cmd{
-		Path: proc.Exec,
-		Args: append([]string{proc.Exec},
-			"--fpm-config", proc.ConfigFile,
-			"-n",  // no php.ini file
-			"-e"), // extended information
-	}
-
-	if cmbOut, err := proc.cmd.CombinedOutput(); err != nil {
-		var ok bool
-		var exitErr *exec.ExitError
-		if exitErr, ok = err.(*exec.ExitError); !ok {
-			// no an exit error
+// commentPoolSection adds the comments to the pool keys
+func commentPoolSection(section *ini.Section) {
+	for name, comment := range poolKeyComments {
+		if section.HasKey(name) {
+			section.Key(name).Comment = comment
+		}
+	}
+}
+
+// ConfigString generates the config like Config does
+// and renders it as string
+func (proc *Process) ConfigString() (string, error) {
+	var buf bytes.Buffer
+	if _, err := proc.WriteConfigTo(&buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// fpmDuration formats the duration in php-fpm time
+// format, rounded up to seconds (e.g. "30s")
+func fpmDuration(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10) + "s"
+}
+
+var reFpmDuration = regexp.MustCompile("^(\\d+)([smhd]?)$")
+
+// parseFpmDuration parses time in php-fpm time format
+// (e.g. "30", "30s", "5m", "1h" or "1d")
+func parseFpmDuration(value string) (d time.Duration, err error) {
+	matches := reFpmDuration.FindStringSubmatch(value)
+	if matches == nil {
+		return 0, fmt.Errorf("invalid duration %#v", value)
+	}
+	n, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %#v", value)
+	}
+	unit := map[string]time.Duration{
+		"":  time.Second,
+		"s": time.Second,
+		"m": time.Minute,
+		"h": time.Hour,
+		"d": time.Hour * 24,
+	}[matches[2]]
+	return time.Duration(n) * unit, nil
+}
+
+// setKeys sets the keys to the section in the
+// sorted order of key names
+func setKeys(section *ini.Section, keys map[string]string) {
+	for _, name := range sortedNames(keys) {
+		section.NewKey(name, keys[name])
+	}
+}
+
+// setArrayKeys sets the values to the section as array
+// keys (e.g. env[NAME]) in the sorted order of names.
+// The values are quoted as needed (see iniValue).
+func setArrayKeys(section *ini.Section, prefix string, values map[string]string) {
+	for _, name := range sortedNames(values) {
+		section.NewKey(prefix+"["+name+"]", iniValue(values[name]))
+	}
+}
+
+// iniOptions are the options of the generated and loaded
+// config. The quoted values containing ";" or "#" are then
+// written as is, instead of being wrapped in backquotes
+// php-fpm cannot read, and are loaded with their quotes
+// (see iniUnquote).
+var iniOptions = ini.LoadOptions{
+	IgnoreInlineComment:     true,
+	PreserveSurroundedQuote: true,
+}
+
+// iniSpecialChars are the characters that PHP's ini parser
+// does not read verbatim in unquoted values
+const iniSpecialChars = ";#\"'$=&|~!^(){}"
+
+// iniEscaper escapes the characters that are special
+// in double quoted values of PHP's ini parser
+var iniEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+
+// iniUnescaper reverses iniEscaper
+var iniUnescaper = strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\$`, `$`)
+
+// iniValue double quotes the value if php-fpm would not
+// read it verbatim otherwise (e.g. "abc;def" is cut at ";")
+func iniValue(value string) string {
+	if !strings.ContainsAny(value, iniSpecialChars) &&
+		strings.TrimSpace(value) == value {
+		return value
+	}
+	return `"` + iniEscaper.Replace(value) + `"`
+}
+
+// iniUnquote reverses iniValue: the surrounding double
+// quotes are removed and the escaping is undone. Single
+// quoted values are read verbatim, as PHP does.
+func iniUnquote(value string) string {
+	if len(value) < 2 || value[0] != value[len(value)-1] {
+		return value
+	}
+	switch value[0] {
+	case '"':
+		return iniUnescaper.Replace(value[1 : len(value)-1])
+	case '\'':
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// checkIniValue checks that the value can be written to
+// the config, which has no quoting for newlines and backquotes
+func checkIniValue(key, value string) error {
+	if strings.ContainsAny(value, "\n\r`") {
+		return fmt.Errorf("%s must not contain newline or backquote", key)
+	}
+	return nil
+}
+
+// checkIniValues checks the values of array keys
+// (e.g. env[NAME]) with checkIniValue
+func checkIniValues(prefix string, values map[string]string) error {
+	for _, name := range sortedNames(values) {
+		if err := checkIniValue(prefix+"["+name+"]", values[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkExtraConfig checks the keys and values of the extra
+// config, which are written as is, with checkIniValue
+func checkExtraConfig(config map[string]string) error {
+	for _, key := range sortedNames(config) {
+		if err := checkIniValue(fmt.Sprintf("key %#v", key), key); err != nil {
 			return err
 		}
-		if !exitErr.ProcessState.Success() {
-			// unsuccessful exitErr
-			return fmt.Errorf("unsuccessful exit. error %s\noutput:\n%s",
-				exitErr.ProcessState, cmbOut)
+		if err := checkIniValue(key, config[key]); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	pid := <-proc.waitPid()
-	spawned, err := os.FindProcess(pid)
-	if err != nil {
-		return
+// copyMap returns a copy of the map, or nil if m is nil
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
 	}
-	proc.cmd.Process = spawned
+	c := make(map[string]string, len(m))
+	for name, value := range m {
+		c[name] = value
+	}
+	return c
+}
 
-	// wait until the service is connectable
-	// or time out
-	select {
-	case <-proc.waitConn():
-		// do nothing
-	case <-time.After(time.Second * 10):
-		// wait 10 seconds or timeout
-		err = fmt.Errorf("time out")
+// copyStrings returns a copy of the slice, or nil if s is nil
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
 	}
+	return append(make([]string, 0, len(s)), s...)
+}
 
-	return
+// sortedNames returns the keys of the map in sorted order
+func sortedNames(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
-// read pid from pid
-func (proc *Process) pid() (pid int, err error) {
-	f, err := os.Open(proc.PidFile)
-	if err != nil {
+// arrayKey parses array key (e.g. env[NAME]) into
+// the prefix and the name
+func arrayKey(key string) (prefix, name string, ok bool) {
+	open := strings.Index(key, "[")
+	if open <= 0 || !strings.HasSuffix(key, "]") {
 		return
 	}
+	return key[:open], key[open+1 : len(key)-1], true
+}
 
-	b, err := ioutil.ReadAll(f)
+// LoadConfig reads an existing config file and sets the
+// process attributes according to its [global] section
+// and pool sections. The first pool section is loaded as
+// the pool of the process and the others as Pools. Keys
+// that are not modeled are kept in ExtraGlobalConfig and
+// ExtraPoolConfig.
+func (proc *Process) LoadConfig(path string) (err error) {
+	f, err := ini.LoadSources(iniOptions, path)
 	if err != nil {
 		return
 	}
 
-	pid64, err := strconv.ParseInt(string(b), 10, 64)
-	pid = int(pid64)
+	extraGlobal := make(map[string]string)
+	for _, key := range f.Section("global").Keys() {
+		key.SetValue(iniUnquote(key.Value()))
+		var target *int
+		var durationTarget *time.Duration
+		switch key.Name() {
+		case "pid":
+			proc.PidFile = key.String()
+		case "error_log":
+			proc.ErrorLog = key.String()
+		case "log_level":
+			proc.LogLevel = key.String()
+		case "emergency_restart_threshold":
+			target = &proc.EmergencyRestartThreshold
+		case "emergency_restart_interval":
+			durationTarget = &proc.EmergencyRestartInterval
+		case "process_control_timeout":
+			durationTarget = &proc.ProcessControlTimeout
+		default:
+			extraGlobal[key.Name()] = key.String()
+		}
+		if target != nil {
+			if *target, err = key.Int(); err != nil {
+				return fmt.Errorf("invalid value %#v for %s", key.String(), key.Name())
+			}
+		}
+		if durationTarget != nil {
+			if *durationTarget, err = parseFpmDuration(key.String()); err != nil {
+				return fmt.Errorf("invalid value %#v for %s", key.String(), key.Name())
+			}
+		}
+	}
+
+	// the first pool section is loaded on top of the current
+	// pool of the process, the others as new pools
+	pools := []Pool{proc.Pool}
+	loaded := false
+	for _, section := range f.Sections() {
+		if name := section.Name(); name == ini.DEFAULT_SECTION || name == "global" {
+			continue
+		}
+		if loaded {
+			pools = append(pools, Pool{})
+		}
+		if err = pools[len(pools)-1].loadSection(section); err != nil {
+			return
+		}
+		loaded = true
+	}
+	if !loaded {
+		if err = pools[0].loadSection(f.Section("www")); err != nil {
+			return
+		}
+	}
+
+	proc.ConfigFile = path
+	proc.ExtraGlobalConfig = extraGlobal
+	proc.Pool = pools[0]
+	proc.Pools = pools[1:]
 	return
 }
 
-// wait until pid file readable
-func (proc *Process) waitPid() <-chan int {
-	cout := make(chan int)
-	go func() {
-		for {
-			if pid, err := proc.pid(); err != nil {
-				time.Sleep(time.Millisecond * 2)
-			} else {
-				cout <- pid
-				break
-			}
-		}
-	}()
-	return cout
+// intOrDefault returns the value, or the default
+// value if the value is zero
+func intOrDefault(value, defaultValue int) int {
+	if value == 0 {
+		return defaultValue
+	}
+	return value
 }
 
-func (proc *Process) waitConn() <-chan net.Conn {
-	chanConn := make(chan net.Conn)
-	go func() {
-		for {
-			if conn, err := net.Dial(proc.Address()); err != nil {
-				time.Sleep(time.Millisecond * 2)
-			} else {
-				chanConn <- conn
-				break
-			}
-		}
-	}()
-	return chanConn
-}
-
-// Address returns networkk and address that fits
-// the use of either net.Dial or net.Listen
-func (proc *Process) Address() (network, address string) {
-	reIP := regexp.MustCompile("^(\\d{1,3}\\.\\d{1,3}\\.\\d{1,3}\\.\\d{1,3})\\:(\\d{2,5}$)")
-	rePort := regexp.MustCompile("^(\\d+)$")
-	switch {
-	case reIP.MatchString(proc.Listen):
-		network = "tcp"
-		address = proc.Listen
-	case rePort.MatchString(proc.Listen):
-		network = "tcp"
-		address = ":" + proc.Listen
-	default:
-		network = "unix"
-		address = proc.Listen
+// durationOrDefault returns the value, or the default
+// value if the value is zero
+func durationOrDefault(value, defaultValue time.Duration) time.Duration {
+	if value == 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// SetDatadir sets default config values according
+// with reference to the folder prefix
+//
+// Equals to running these 6 statements:
+//   process.ConfigFile = basepath + "/phpfpm.conf"
+//   process.PidFile    = basepath + "/phpfpm.pid"
+//   process.ErrorLog   = basepath + "/phpfpm.error_log"
+//   process.SlowLog    = basepath + "/phpfpm.slow_log"
+//   process.AccessLog  = basepath + "/phpfpm.access_log"
+//   process.Listen     = basepath + "/phpfpm.sock"
+//
+// Returns error if the prefix folder doesn't exists
+// or is not a folder. The values are not set in that case.
+func (proc *Process) SetDatadir(prefix string) (err error) {
+	return proc.SetDatadirNamed(prefix, "phpfpm")
+}
+
+// SetDatadirNamed works like SetDatadir, but names the
+// files after the given name instead of "phpfpm". Useful
+// for running multiple processes with the same datadir.
+//
+// Equals to running these 6 statements:
+//   process.ConfigFile = basepath + "/" + name + ".conf"
+//   process.PidFile    = basepath + "/" + name + ".pid"
+//   process.ErrorLog   = basepath + "/" + name + ".error_log"
+//   process.SlowLog    = basepath + "/" + name + ".slow_log"
+//   process.AccessLog  = basepath + "/" + name + ".access_log"
+//   process.Listen     = basepath + "/" + name + ".sock"
+func (proc *Process) SetDatadirNamed(prefix, name string) (err error) {
+	info, err := os.Stat(prefix)
+	if err != nil {
+		return fmt.Errorf("invalid datadir: %s", err)
 	}
+	if !info.IsDir() {
+		return fmt.Errorf("invalid datadir: %s is not a directory", prefix)
+	}
+	proc.ConfigFile = path.Join(prefix, name+".conf")
+	proc.PidFile = path.Join(prefix, name+".pid")
+	proc.ErrorLog = path.Join(prefix, name+".error_log")
+	proc.SlowLog = path.Join(prefix, name+".slow_log")
+	proc.AccessLog = path.Join(prefix, name+".access_log")
+	proc.Listen = path.Join(prefix, name+".sock")
+	proc.datadirErr = nil // clears a previous WithDatadir error
 	return
 }
 
-// Stop stops the php-fpm process with SIGINT
-// instead of killing
-func (proc *Process) Stop() error {
-	return proc.cmd.Process.Signal(os.Interrupt)
+// Start starts the php-fpm process
+// in foreground mode instead of daemonize
+// (unless Daemonize is set)
+//
+// The stdout and stderr of the process are returned as
+// pipes. Caller should consume them or php-fpm may block
+// once the pipe buffer is full. Use StdoutTo and StderrTo
+// to have them written to an io.Writer instead.
+func (proc *Process) Start() (stdout, stderr io.ReadCloser, err error) {
+	return proc.StartContext(context.Background())
 }
 
-// Wait wait for the process to finish
-func (proc *Process) Wait() (err error) {
-	for {
-		if err = proc.cmd.Process.Signal(syscall.Signal(0)); err != nil {
-			switch err.Error() {
-			case "os: process already finished":
-				fallthrough
-			case "no such process":
-				err = nil
-			}
-			break
-		} else {
-			time.Sleep(time.Millisecond * 2)
+// StartContext starts the php-fpm process like Start does.
+// If the context is done before php-fpm is connectable, the
+// started process (and the daemon, in daemon mode) is killed
+// and the context's error is returned.
+//
+// Returns ErrAlreadyStarted if another start is in progress
+// or the process of a previous start has not exited, as it
+// would be left running with no way to stop it.
+func (proc *Process) StartContext(ctx context.Context) (stdout, stderr io.ReadCloser, err error) {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+
+	// the pipes are of no use to the caller on error
+	defer func() {
+		if err == nil {
+			return
+		}
+		if stdout != nil {
+			stdout.Close()
+		}
+		if stderr != nil {
+			stderr.Close()
+		}
+		stdout, stderr = nil, nil
+	}()
+
+	if proc.starting || (proc.exit != nil && !proc.hasExited()) {
+		err = ErrAlreadyStarted
+		return
+	}
+
+	var connected <-chan struct{}
+	var logged <-chan struct{}
+
+	switch proc.ReadinessMode {
+	case "", ReadinessSocket, ReadinessLog:
+		// supported
+	default:
+		err = fmt.Errorf("unsupported readiness mode %#v", proc.ReadinessMode)
+		return
+	}
+	if err = proc.Cleanup(); err != nil {
+		err = proc.startError(StartPhaseCleanup, err)
+		return
+	}
+	execPath, err := proc.execPath()
+	if err != nil {
+		err = proc.startError(StartPhaseExec, err)
+		return
+	}
+	if proc.ConfigFile == "" || proc.ConfigFile == proc.tempConfig {
+		if err = proc.saveTempConfig(); err != nil {
+			err = proc.startError(StartPhaseConfig, err)
+			return
+		}
+		defer func() {
+			if proc.exit == nil {
+				// not removed by the waiter
+				proc.removeTempConfig(proc.tempConfig)
+			}
+		}()
+	}
+
+	proc.cmd = &exec.Cmd{
+		Path: execPath,
+		Args: proc.args(),
+		Env:  proc.ProcessEnv,
+		Dir:  proc.WorkingDir,
+	}
+	proc.daemon = nil
+	proc.stopped = false
+	proc.exit = nil
+
+	// write ends of the pipes, closed once the child process
+	// is started, as only the child process writes to them
+	var pipes []*os.File
+	defer func() {
+		for _, w := range pipes {
+			w.Close()
+		}
+	}()
+	var w *os.File
+	if proc.StdoutTo != nil {
+		proc.cmd.Stdout = proc.StdoutTo
+	} else if proc.discardOutput {
+		proc.cmd.Stdout = ioutil.Discard
+	} else if stdout, w, err = outputPipe(); err != nil {
+		err = proc.startError(StartPhasePipe, err)
+		return
+	} else {
+		proc.cmd.Stdout = w
+		pipes = append(pipes, w)
+	}
+	tail := newLineTail(stderrTailLines)
+	if stderr, w, logged, err = proc.watchStderr(tail); err != nil {
+		err = proc.startError(StartPhasePipe, err)
+		return
+	}
+	proc.cmd.Stderr = w
+	pipes = append(pipes, w)
+	if proc.ReadinessMode != ReadinessLog {
+		logged = nil
+	}
+	err = proc.cmd.Start()
+	for _, w := range pipes {
+		w.Close()
+	}
+	pipes = nil
+	if err != nil {
+		err = proc.startError(StartPhaseExec, err)
+		return
+	}
+
+	// in daemon mode, the launching process exits once
+	// php-fpm is daemonized, and fails if it is not
+	var exited <-chan struct{}
+	var launcher *launcherStatus
+	if !proc.Daemonize {
+		// tail is closed once the stderr is all copied
+		proc.startWaiter(proc.cmd.Wait, tail.done)
+		exited = proc.exit.done
+	} else {
+		launcher = waitLauncher(proc.cmd.Process)
+		exited = launcher.failed
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	if logged == nil {
+		connected = proc.waitConn(stop)
+	}
+	if proc.StrictReadiness {
+		if connected != nil {
+			connected = proc.waitPidFile(connected, stop)
+		}
+		if logged != nil {
+			logged = proc.waitPidFile(logged, stop)
+		}
+	}
+
+	timeout := proc.StartTimeout
+	if timeout == 0 {
+		timeout = time.Second * 10
+	}
+
+	// wait until the service is connectable, the process
+	// exits or time out. mu is released meanwhile, so the
+	// other methods are not blocked
+	proc.starting = true
+	proc.mu.Unlock()
+	relock := func() {
+		proc.mu.Lock()
+		proc.starting = false
+	}
+	select {
+	case <-connected:
+		relock()
+		if err = proc.reapLauncher(launcher); err != nil {
+			err = proc.startError(StartPhaseDaemonize, err)
+		}
+	case <-logged:
+		relock()
+		if err = proc.reapLauncher(launcher); err != nil {
+			err = proc.startError(StartPhaseDaemonize, err)
+		}
+	case <-ctx.Done():
+		relock()
+		// kill the process and release its resources
+		proc.killStarting(launcher)
+		err = ctx.Err()
+	case <-exited:
+		relock()
+		phase, msg := StartPhaseExit, "php-fpm exited before being ready"
+		if proc.Daemonize {
+			// kill the daemon the failed launcher may leave
+			proc.killStarting(launcher)
+			phase, msg = StartPhaseDaemonize, "php-fpm failed to daemonize"
+		}
+		// the output may still be in the pipe
+		select {
+		case <-tail.done:
+		case <-time.After(time.Millisecond * 100):
+		}
+		cause := proc.exit.err
+		if cause == nil && proc.exit.launcher != nil {
+			cause = fmt.Errorf("%s", proc.exit.launcher)
+		} else if cause == nil {
+			cause = fmt.Errorf("%s", proc.exit.state)
+		}
+		err = proc.startError(phase, tail.appendTo(
+			fmt.Sprintf("%s (%s)", msg, cause)))
+	case <-time.After(timeout):
+		relock()
+		// php-fpm is not usable, kill it like above
+		proc.killStarting(launcher)
+		err = proc.startError(StartPhaseTimeout, tail.appendTo(
+			fmt.Sprintf("timed out after %s waiting for php-fpm to be ready", timeout)))
+	}
+
+	return
+}
+
+// saveTempConfig saves the config to a temporary
+// file and sets it as ConfigFile
+func (proc *Process) saveTempConfig() (err error) {
+	file, err := ioutil.TempFile("", "gophpfpm-*.conf")
+	if err != nil {
+		return
+	}
+	if _, err = proc.WriteConfigTo(file); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(file.Name())
+		return
+	}
+	proc.ConfigFile = file.Name()
+	proc.tempConfig = file.Name()
+	return
+}
+
+// removeTempConfig removes the temporary config file and
+// unsets ConfigFile if it is still the file, so the next
+// Start saves a new one. Must be called with mu held.
+func (proc *Process) removeTempConfig(tempConfig string) {
+	os.Remove(tempConfig)
+	if proc.ConfigFile == tempConfig {
+		proc.ConfigFile = ""
+	}
+	if proc.tempConfig == tempConfig {
+		proc.tempConfig = ""
+	}
+}
+
+// startError wraps the error of Start in a StartError
+func (proc *Process) startError(phase string, err error) error {
+	network, address := proc.Address()
+	return &StartError{
+		Phase: phase,
+		Net:   network,
+		Addr:  address,
+		Err:   err,
+	}
+}
+
+// outputPipe creates a pipe for the output of the process.
+// Unlike cmd.StdoutPipe, the reader is not closed once the
+// process is waited for, so the output can still be read
+// after php-fpm exits.
+func outputPipe() (r io.ReadCloser, w *os.File, err error) {
+	pr, w, err := os.Pipe()
+	if err != nil {
+		return
+	}
+	return pr, w, nil
+}
+
+// startWaiter waits for the process in background with the
+// wait function, then for output to be closed once the output
+// of the process is all copied (if not nil), for up to
+// outputWaitDelay in case a child process keeps the pipe
+// open. Once done, the exit state is stored,
+// the files of the process are removed (unless KeepFiles is
+// set) along with the temporary config file (unsetting
+// ConfigFile), Wait returns and OnExit is called. Must be
+// called with mu held.
+func (proc *Process) startWaiter(wait func() error, output <-chan struct{}) {
+	exit := &exitStatus{done: make(chan struct{})}
+	proc.exit = exit
+	cmd := proc.cmd
+	var tempConfig string
+	if proc.ConfigFile == proc.tempConfig {
+		tempConfig = proc.tempConfig
+	}
+	go func() {
+		err := wait()
+		if output != nil {
+			// like cmd.Wait, which also waits for
+			// the output to be copied
+			select {
+			case <-output:
+			case <-time.After(outputWaitDelay):
+			}
+		}
+		if err == nil && !proc.KeepFiles {
+			proc.removeFiles()
+		}
+		if tempConfig != "" {
+			proc.mu.Lock()
+			proc.removeTempConfig(tempConfig)
+			proc.mu.Unlock()
+		}
+		exit.state, exit.err = cmd.ProcessState, err
+		close(exit.done)
+		if proc.OnExit != nil {
+			proc.OnExit(cmd.ProcessState, err)
+		}
+	}()
+}
+
+// outputWaitDelay is how long the waiter waits for the
+// output to be copied after the process exits
+const outputWaitDelay = time.Second * 5
+
+// hasExited checks if the started process has been waited
+// for. Must be called with mu held.
+func (proc *Process) hasExited() bool {
+	if proc.exit == nil {
+		return false
+	}
+	select {
+	case <-proc.exit.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stderrTailLines is the number of stderr lines
+// reported when Start() times out
+const stderrTailLines = 10
+
+// capturedStderrLines is the number of stderr
+// lines kept for StderrTail()
+const capturedStderrLines = 100
+
+// StderrTail returns the last n lines of the stderr of the
+// process of the last Start(), or all the kept lines (up to
+// 100) if n <= 0. Returns nil if CaptureStderr was not set.
+func (proc *Process) StderrTail(n int) []string {
+	proc.mu.Lock()
+	captured := proc.stderrTail
+	proc.mu.Unlock()
+	if captured == nil {
+		return nil
+	}
+	return captured.Lines(n)
+}
+
+// watchStderr creates a pipe for the stderr of the process.
+// The output is scanned for the "fpm is running" notice, which
+// closes the returned channel, and its last lines are kept in
+// tail (and in stderrTail if CaptureStderr is set). It is then
+// forwarded to StderrTo or, if not set, to the returned reader
+// unless it is only captured or discarded. Must be called
+// with mu held.
+func (proc *Process) watchStderr(tail *lineTail) (stderr io.ReadCloser, w *os.File, ready <-chan struct{}, err error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return
+	}
+
+	watcher := newLogWatcher()
+	writers := []io.Writer{watcher, tail}
+	proc.stderrTail = nil
+	if proc.CaptureStderr {
+		proc.stderrTail = newLineTail(capturedStderrLines)
+		writers = append(writers, proc.stderrTail)
+	}
+	out := proc.StderrTo
+	var pw *os.File
+	if out == nil && (proc.CaptureStderr || proc.discardOutput) {
+		out = ioutil.Discard
+	} else if out == nil {
+		if stderr, pw, err = outputPipe(); err != nil {
+			r.Close()
+			w.Close()
+			return
+		}
+		out = pw
+	}
+	go func() {
+		io.Copy(io.MultiWriter(append(writers, out)...), r)
+		r.Close()
+		if pw != nil {
+			pw.Close()
+		}
+		tail.Close()
+	}()
+	return stderr, w, watcher.ready, nil
+}
+
+// args returns the command line arguments
+// to start php-fpm with
+func (proc *Process) args() []string {
+	args := []string{proc.Exec,
+		"--fpm-config", proc.ConfigFile,
+	}
+	if !proc.Daemonize {
+		args = append(args, "-F") // foreground
+	}
+	args = append(args, proc.iniArgs()...)
+	args = append(args, "-e") // extended information
+	if proc.AllowRunAsRoot {
+		args = append(args, "--allow-to-run-as-root")
+	}
+	return append(args, proc.ExtraArgs...)
+}
+
+// TestConfig runs php-fpm with -t to test ConfigFile
+// without starting the process. Returns error with the
+// output of php-fpm if the config file is invalid.
+func (proc *Process) TestConfig() error {
+	if proc.ConfigFile == "" {
+		return fmt.Errorf("config file is not set")
+	}
+	execPath, err := proc.execPath()
+	if err != nil {
+		return err
+	}
+	args := []string{proc.Exec,
+		"--fpm-config", proc.ConfigFile,
+	}
+	args = append(args, proc.iniArgs()...)
+	if proc.AllowRunAsRoot {
+		args = append(args, "--allow-to-run-as-root")
+	}
+	args = append(args, "-t") // test config
+
+	var output bytes.Buffer
+	cmd := &exec.Cmd{
+		Path:   execPath,
+		Args:   args,
+		Env:    proc.ProcessEnv,
+		Dir:    proc.WorkingDir,
+		Stdout: &output,
+		Stderr: &output,
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("invalid config file %s (%s): %s",
+			proc.ConfigFile, err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// reVersion matches the version line of php-fpm -v
+// (e.g. "PHP 8.2.10 (fpm-fcgi) (built: ...)")
+var reVersion = regexp.MustCompile("(?m)^PHP (\\S+) \\(fpm-fcgi\\)")
+
+// Version runs php-fpm with -v and returns the PHP
+// version of it (e.g. "8.2.10")
+func (proc *Process) Version() (string, error) {
+	execPath, err := proc.execPath()
+	if err != nil {
+		return "", err
+	}
+	var output bytes.Buffer
+	cmd := &exec.Cmd{
+		Path:   execPath,
+		Args:   []string{proc.Exec, "-n", "-v"},
+		Env:    proc.ProcessEnv,
+		Stdout: &output,
+		Stderr: &output,
+	}
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("unable to get php-fpm version (%s): %s",
+			err, strings.TrimSpace(output.String()))
+	}
+	matches := reVersion.FindStringSubmatch(output.String())
+	if matches == nil {
+		return "", fmt.Errorf("unexpected php-fpm version output: %#v", output.String())
+	}
+	return matches[1], nil
+}
+
+// execPath returns the path to run Exec with. If Exec
+// contains no path separator, it is searched in the PATH
+func (proc *Process) execPath() (string, error) {
+	if strings.ContainsRune(proc.Exec, os.PathSeparator) {
+		return proc.Exec, nil
+	}
+	execPath, err := exec.LookPath(proc.Exec)
+	if err != nil {
+		return "", fmt.Errorf("php-fpm executable %#v is not found in PATH: %s", proc.Exec, err)
+	}
+	return execPath, nil
+}
+
+// iniArgs returns the arguments to load PhpIni
+func (proc *Process) iniArgs() []string {
+	if proc.PhpIni != "" {
+		return []string{"-c", proc.PhpIni}
+	}
+	return []string{"-n"} // no php.ini file
+}
+
+// reapLauncher waits for the launching process to exit
+// once the daemon is ready. Does nothing in foreground mode.
+// The pipes are left open as the daemon may inherit them.
+// Must be called with mu held.
+func (proc *Process) reapLauncher(launcher *launcherStatus) error {
+	if !proc.Daemonize {
+		return nil
+	}
+	<-launcher.done
+	state, err := launcher.state, launcher.err
+	if err != nil {
+		return err
+	}
+	if !state.Success() {
+		err = fmt.Errorf("php-fpm failed to daemonize: %s", state)
+		proc.startWaiter(func() error { return err }, nil)
+		proc.exit.launcher = state
+		return err
+	}
+	pid, err := proc.ReadPidFile()
+	if err != nil {
+		return err
+	}
+	daemon, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	proc.daemon = daemon
+	proc.startWaiter(func() error { return waitDaemon(daemon) }, nil)
+	proc.exit.launcher = state
+	return nil
+}
+
+// killStarting kills the php-fpm process that is not ready
+// and waits for it to exit. In daemon mode, the daemon found
+// with PidFile is killed along with the launching process.
+// Must be called with mu held.
+func (proc *Process) killStarting(launcher *launcherStatus) {
+	if !proc.Daemonize {
+		proc.cmd.Process.Kill()
+		proc.waitExit()
+		return
+	}
+	var daemon *os.Process
+	if pid, err := proc.ReadPidFile(); err == nil {
+		daemon, _ = os.FindProcess(pid)
+	}
+	proc.cmd.Process.Kill()
+	if daemon != nil {
+		daemon.Kill()
+	}
+
+	<-launcher.done
+	state, err := launcher.state, launcher.err
+	proc.startWaiter(func() error {
+		if daemon != nil {
+			waitDaemon(daemon)
+		}
+		return err
+	}, nil)
+	proc.exit.launcher = state
+	proc.waitExit()
+}
+
+// waitExit waits for the started process to be waited for.
+// mu is released meanwhile, as the waiter takes it, and
+// another Start is refused. Must be called with mu held.
+func (proc *Process) waitExit() {
+	done := proc.exit.done
+	proc.starting = true
+	proc.mu.Unlock()
+	<-done
+	proc.mu.Lock()
+	proc.starting = false
+}
+
+// launcherStatus is the result of waiting for the launching
+// process in daemon mode. state and err are set before done
+// is closed. failed is also closed if the launcher exits
+// unsuccessfully, i.e. php-fpm fails to daemonize.
+type launcherStatus struct {
+	done   chan struct{}
+	failed chan struct{}
+	state  *os.ProcessState
+	err    error
+}
+
+// waitLauncher waits for the launching process in background.
+// Not cmd.Wait, which also waits for the output copied from
+// the pipes the daemon may have inherited.
+func waitLauncher(launcher *os.Process) *launcherStatus {
+	status := &launcherStatus{
+		done:   make(chan struct{}),
+		failed: make(chan struct{}),
+	}
+	go func() {
+		status.state, status.err = launcher.Wait()
+		close(status.done)
+		if status.err != nil || !status.state.Success() {
+			close(status.failed)
+		}
+	}()
+	return status
+}
+
+// waitDaemon polls until the daemon is gone, as
+// it is not a child process
+func waitDaemon(daemon *os.Process) error {
+	for isAlive(daemon) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	return nil
+}
+
+// master returns the php-fpm master process. In daemon
+// mode, it is the process found with PidFile on start.
+// Must be called with mu held.
+func (proc *Process) master() (*os.Process, error) {
+	if proc.cmd == nil || proc.cmd.Process == nil {
+		return nil, ErrNotStarted
+	}
+	if proc.daemon != nil {
+		return proc.daemon, nil
+	}
+	return proc.cmd.Process, nil
+}
+
+// waitConn probes the listen address until php-fpm accepts
+// connection, or until stop is closed. The returned channel
+// is closed once php-fpm accepts connection. The probe
+// connection is closed right away.
+func (proc *Process) waitConn(stop <-chan struct{}) <-chan struct{} {
+	connected := make(chan struct{})
+	network, address := proc.Address()
+	interval := proc.ReadinessPollInterval
+	if interval == 0 {
+		interval = time.Millisecond * 2
+	}
+	go func() {
+		for {
+			conn, err := net.Dial(network, address)
+			if err == nil {
+				conn.Close()
+				close(connected)
+				return
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+	return connected
+}
+
+// waitPidFile waits for ready to be closed, then polls until
+// PidFile has a valid pid. The returned channel is closed
+// once both are done. Polling stops when stop is closed.
+func (proc *Process) waitPidFile(ready <-chan struct{}, stop <-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+	interval := proc.ReadinessPollInterval
+	if interval == 0 {
+		interval = time.Millisecond * 2
+	}
+	go func() {
+		select {
+		case <-stop:
+			return
+		case <-ready:
+		}
+		for {
+			if pid, err := proc.ReadPidFile(); err == nil && pid > 0 {
+				close(done)
+				return
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+	return done
+}
+
+// Cleanup removes the files left by a previous php-fpm
+// process that did not shut down cleanly. It is called by
+// Start before starting php-fpm.
+//
+// The unix socket is removed only if it is a socket that
+// no longer accepts connection. The pid file is removed
+// only if the process in it is no longer running. Returns
+// error if a non-socket file exists at the socket path.
+func (proc *Process) Cleanup() error {
+	if network, address := proc.Address(); network == "unix" {
+		info, err := os.Lstat(address)
+		switch {
+		case os.IsNotExist(err):
+			// nothing to clean
+		case err != nil:
+			return err
+		case info.Mode()&os.ModeSocket == 0:
+			return fmt.Errorf("%s exists and is not a socket", address)
+		default:
+			if conn, err := net.Dial(network, address); err == nil {
+				// still served by a running process
+				conn.Close()
+				break
+			}
+			if err := os.Remove(address); err != nil {
+				return err
+			}
+		}
+	}
+
+	if proc.PidFile == "" {
+		return nil
+	}
+	if _, err := os.Stat(proc.PidFile); os.IsNotExist(err) {
+		return nil
+	}
+	if pid, err := proc.ReadPidFile(); err == nil && pid > 0 {
+		if running, err := os.FindProcess(pid); err == nil && isAlive(running) {
+			// the process is still running
+			return nil
+		}
+	}
+	return os.Remove(proc.PidFile)
+}
+
+// Stop stops the php-fpm process with SIGINT
+// instead of killing
+func (proc *Process) Stop() error {
+	return proc.StopWithSignal(os.Interrupt)
+}
+
+// StopWithSignal stops the php-fpm process with the given
+// signal. php-fpm handles the signals differently:
+//
+// SIGQUIT is a graceful stop. Workers finish the requests
+// in progress before the process exits.
+//
+// SIGINT and SIGTERM are immediate termination. They are
+// faster, but requests in progress are dropped.
+func (proc *Process) StopWithSignal(sig os.Signal) error {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	if _, err := proc.master(); err != nil {
+		return err
+	}
+	proc.stopped = true
+	return proc.signal(sig)
+}
+
+// Signal sends the given signal to the php-fpm master
+// process. Unlike StopWithSignal, the process is not
+// considered stopped by Supervise. php-fpm handles:
+//
+// SIGINT, SIGTERM: immediate termination
+//
+// SIGQUIT: graceful stop
+//
+// SIGUSR1: reopen log files
+//
+// SIGUSR2: graceful reload of the config file
+//
+// On windows, only os.Kill can be delivered. The signals
+// that stop php-fpm kill the process instead, and the
+// others return error.
+func (proc *Process) Signal(sig os.Signal) error {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	return proc.signal(sig)
+}
+
+// signal sends the signal to the master process.
+// Must be called with mu held.
+func (proc *Process) signal(sig os.Signal) error {
+	master, err := proc.master()
+	if err != nil {
+		return err
+	}
+	return signalProcess(master, sig)
+}
+
+// StopContext stops the php-fpm process gracefully with SIGQUIT
+// and waits for it to finish. If the context is done before the
+// process exits, the process is killed and the context's error
+// is returned.
+func (proc *Process) StopContext(ctx context.Context) (err error) {
+	proc.mu.Lock()
+	master, err := proc.master()
+	if err != nil {
+		proc.mu.Unlock()
+		return
+	}
+	proc.stopped = true
+	err = signalProcess(master, sigGracefulStop)
+	proc.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	waited := make(chan error, 1)
+	go func() {
+		waited <- proc.Wait()
+	}()
+
+	select {
+	case err = <-waited:
+		return
+	case <-ctx.Done():
+		killErr := master.Kill()
+		<-waited
+		if killErr != nil {
+			return fmt.Errorf("%w (kill: %s)", ctx.Err(), killErr)
+		}
+		return ctx.Err()
+	}
+}
+
+// drainPollInterval is the interval between the status
+// page fetches of Drain
+const drainPollInterval = 100 * time.Millisecond
+
+// Drain stops the php-fpm process gracefully for zero-downtime
+// deploys. It sends SIGQUIT, so php-fpm stops accepting new
+// connections and lets the workers finish the requests in
+// progress, then waits for the process to exit.
+//
+// If StatusPath is set, the status page is polled in between
+// until no request other than the status request itself is
+// active, or the page is no longer served.
+//
+// If the context is done first, the context's error is
+// returned and the process is left to finish on its own.
+// Use StopContext to kill it instead.
+func (proc *Process) Drain(ctx context.Context) error {
+	proc.mu.Lock()
+	master, err := proc.master()
+	if err != nil {
+		proc.mu.Unlock()
+		return err
+	}
+	proc.stopped = true
+	err = signalProcess(master, sigGracefulStop)
+	proc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if proc.StatusPath != "" {
+		ticker := time.NewTicker(drainPollInterval)
+		defer ticker.Stop()
+		for {
+			status, err := proc.Status()
+			if err != nil || status.ActiveProcesses <= 1 {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+		}
+	}
+	_, err = proc.WaitContext(ctx)
+	return err
+}
+
+// Reload sends SIGUSR2 to the php-fpm process so it
+// gracefully reloads the config file and restarts workers
+func (proc *Process) Reload() error {
+	return proc.Signal(sigReload)
+}
+
+// ReopenLogs sends SIGUSR1 to the php-fpm process so it
+// reopens the log files (ErrorLog, AccessLog and SlowLog).
+// Useful after the files are rotated.
+func (proc *Process) ReopenLogs() error {
+	return proc.Signal(sigReopenLogs)
+}
+
+// Pid returns the pid of the php-fpm master process.
+// Returns -1 if the process is not started (or Start has
+// not returned yet) or has been waited for.
+func (proc *Process) Pid() int {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	if proc.starting || proc.cmd == nil || proc.cmd.Process == nil || proc.hasExited() {
+		return -1
+	}
+	master, err := proc.master()
+	if err != nil {
+		return -1
+	}
+	return master.Pid
+}
+
+// ReadPidFile reads the pid of the php-fpm master
+// process from PidFile
+func (proc *Process) ReadPidFile() (pid int, err error) {
+	b, err := ioutil.ReadFile(proc.PidFile)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read pid file: %s", err)
+	}
+	if pid, err = strconv.Atoi(strings.TrimSpace(string(b))); err != nil {
+		return 0, fmt.Errorf("malformed pid file %s: %#v", proc.PidFile, string(b))
+	}
+	return
+}
+
+// IsRunning checks if the php-fpm process is started
+// and still exists. Returns false until Start returns,
+// and once the process has been waited for.
+func (proc *Process) IsRunning() bool {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	if proc.starting || proc.cmd == nil || proc.cmd.Process == nil || proc.hasExited() {
+		return false
+	}
+	master, err := proc.master()
+	if err != nil {
+		return false
+	}
+	return isAlive(master)
+}
+
+// Restart stops the process, waits for it to finish and
+// starts it again with the current config file. If the
+// process is not running, it is simply started. Returns
+// ErrAlreadyStarted if a Start has not returned yet.
+func (proc *Process) Restart() (stdout, stderr io.ReadCloser, err error) {
+	proc.mu.Lock()
+	starting := proc.starting
+	proc.mu.Unlock()
+	if starting {
+		return nil, nil, ErrAlreadyStarted
+	}
+	if proc.IsRunning() {
+		if err = proc.Stop(); err != nil {
+			return
+		}
+		if err = proc.Wait(); err != nil {
+			return
+		}
+	} else if done := proc.Done(); done != nil {
+		// exited but may not be waited for yet
+		<-done
+	}
+	return proc.Start()
+}
+
+// Done returns a channel that is closed once the started
+// process exits and has been waited for. Returns nil if
+// the process is not started, which blocks forever.
+func (proc *Process) Done() <-chan struct{} {
+	exit := proc.lastExit()
+	if exit == nil {
+		return nil
+	}
+	return exit.done
+}
+
+// Wait wait for the process to finish. It is
+// safe to be called multiple times
+func (proc *Process) Wait() (err error) {
+	exit := proc.lastExit()
+	if exit == nil {
+		return ErrNotStarted
+	}
+	<-exit.done
+	return exit.err
+}
+
+// WaitContext waits for the process to finish, or for
+// the context to be done. The process is not stopped when
+// the context is done. It is safe to be called multiple
+// times.
+func (proc *Process) WaitContext(ctx context.Context) (*os.ProcessState, error) {
+	exit := proc.lastExit()
+	if exit == nil {
+		return nil, ErrNotStarted
+	}
+	select {
+	case <-exit.done:
+		return exit.state, exit.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// ExitCode returns the exit code of the exited process.
+// Returns -1 if the process is not started, still running
+// or is terminated by a signal. In daemon mode, it is the
+// exit code of the launcher process.
+func (proc *Process) ExitCode() int {
+	state := proc.exitState()
+	if state == nil {
+		return -1
+	}
+	return state.ExitCode()
+}
+
+// ExitedBySignal returns the signal which terminated the
+// exited process, if any. In daemon mode, it is the signal
+// which terminated the launcher process.
+func (proc *Process) ExitedBySignal() (os.Signal, bool) {
+	state := proc.exitState()
+	if state == nil {
+		return nil, false
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return nil, false
+	}
+	return status.Signal(), true
+}
+
+// lastExit returns the exit status of the last
+// started process, or nil if not started
+func (proc *Process) lastExit() *exitStatus {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	return proc.exit
+}
+
+// exitState returns the state of the exited process (of
+// the launcher process in daemon mode), or nil if it has
+// not exited
+func (proc *Process) exitState() *os.ProcessState {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	if !proc.hasExited() {
+		return nil
+	}
+	if proc.exit.launcher != nil {
+		return proc.exit.launcher
+	}
+	return proc.exit.state
+}
+
+// removeFiles removes the pid file and the unix socket
+// of an exited process, ignoring any error
+func (proc *Process) removeFiles() {
+	if proc.PidFile != "" {
+		os.Remove(proc.PidFile)
+	}
+	if network, address := proc.Address(); network == "unix" {
+		if info, err := os.Lstat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
 		}
 	}
-	return
 }
@@ -1,11 +1,26 @@
 package gophpfpm_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"net"
 	"os"
 	"path"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/go-ini/ini"
 	"github.com/yookoala/gophpfpm"
 )
 
@@ -36,21 +51,233 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNewProcessChecked(t *testing.T) {
+	process, err := gophpfpm.NewProcessChecked(pathToPhpFpm)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := pathToPhpFpm, process.Exec; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// not exists, a directory and not executable
+	for _, path := range []string{
+		basepath + "/not-exists/php-fpm",
+		basepath + "/var",
+		basepath + "/etc/.gitignore",
+	} {
+		if _, err := gophpfpm.NewProcessChecked(path); err == nil {
+			t.Errorf("expected error for %#v, got nil", path)
+		}
+	}
+}
+
+func TestProcess_Clone(t *testing.T) {
+	base := gophpfpm.NewProcess(pathToPhpFpm)
+	base.SetDatadir(basepath + "/var")
+	base.Pools = []gophpfpm.Pool{gophpfpm.NewPool("other", "127.0.0.1:9001")}
+	base.Pools[0].Env = map[string]string{"POOL": "other"}
+	base.LogLevel = "debug"
+	base.EmergencyRestartThreshold = 10
+	base.EmergencyRestartInterval = time.Minute
+	base.ProcessControlTimeout = time.Second
+	base.PhpIni = "/etc/php.ini"
+	base.AllowRunAsRoot = true
+	base.ExtraArgs = []string{"-d", "foo=bar"}
+	base.Daemonize = true
+	base.KeepFiles = true
+	base.ProcessEnv = []string{"PATH=/usr/bin"}
+	base.WorkingDir = "/tmp"
+	base.StdoutTo = &bytes.Buffer{}
+	base.StderrTo = &bytes.Buffer{}
+	base.CaptureStderr = true
+	base.ReadinessMode = gophpfpm.ReadinessLog
+	base.StartTimeout = time.Second
+	base.ReadinessPollInterval = time.Millisecond
+	base.StrictReadiness = true
+	base.FcgiConnectTimeout = time.Second
+	base.FcgiReadTimeout = time.Second
+	base.RestartBackoffInitial = time.Second
+	base.RestartBackoffMax = time.Minute
+	base.MaxRestarts = 3
+	base.StopGracePeriod = time.Second
+	base.OnExit = func(*os.ProcessState, error) {}
+	base.ExtraGlobalConfig = map[string]string{"daemonize": "no"}
+	base.PoolName = "tenant"
+	base.User = "nobody"
+	base.Group = "nogroup"
+	base.ListenOwner = "nobody"
+	base.ListenGroup = "nogroup"
+	base.ListenMode = "0660"
+	base.AllowedClients = []string{"127.0.0.1"}
+	base.ListenBacklog = 511
+	base.ProcessManager = gophpfpm.PMDynamic
+	base.MaxChildren = 20
+	base.StartServers = 4
+	base.MinSpareServers = 2
+	base.MaxSpareServers = 6
+	base.ProcessIdleTimeout = time.Second
+	base.MaxRequests = 500
+	base.StatusPath = "/status"
+	base.PingPath = "/ping"
+	base.PingResponse = "pong"
+	base.RequestTerminateTimeout = time.Minute
+	base.RequestSlowlogTimeout = time.Second
+	base.AccessFormat = "%R %m %r"
+	base.CatchWorkersOutput = true
+	base.Chroot = "/var/www"
+	base.Chdir = "/"
+	base.KeepEnv = true
+	base.LimitExtensions = []string{".php", ".phtml"}
+	base.RlimitFiles = 1024
+	base.RlimitCore = 1
+	base.Env = map[string]string{"APP_ENV": "production"}
+	base.PHPAdminValue = map[string]string{"memory_limit": "128M"}
+	base.PHPValue = map[string]string{"display_errors": "off"}
+	base.ExtraPoolConfig = map[string]string{"pm.status_listen": "127.0.0.1:9100"}
+
+	// all the config fields are set for the test to cover them
+	var checkSet func(prefix string, v reflect.Value)
+	checkSet = func(prefix string, v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue // unexported
+			}
+			if field.Anonymous {
+				checkSet(field.Name+".", v.Field(i))
+			} else if v.Field(i).IsZero() {
+				t.Errorf("expected %s%s to be set in the test", prefix, field.Name)
+			}
+		}
+	}
+	checkSet("", reflect.ValueOf(base).Elem())
+
+	clone := base.Clone()
+	if clone.OnExit == nil {
+		t.Errorf("expected OnExit to be copied")
+	}
+	onExit := base.OnExit
+	base.OnExit, clone.OnExit = nil, nil
+	if !reflect.DeepEqual(base, clone) {
+		t.Errorf("expected %#v, got %#v", base, clone)
+	}
+	base.OnExit = onExit
+
+	// maps and slices are independent
+	clone.Env["APP_ENV"] = "testing"
+	clone.ExtraArgs[0] = "-n"
+	clone.AllowedClients[0] = "10.0.0.1"
+	clone.Pools[0].Env["POOL"] = "changed"
+	clone.Pools[0].Listen = "127.0.0.1:9002"
+	clone.ExtraGlobalConfig["daemonize"] = "yes"
+	if want, have := "production", base.Env["APP_ENV"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "-d", base.ExtraArgs[0]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "127.0.0.1", base.AllowedClients[0]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "other", base.Pools[0].Env["POOL"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "127.0.0.1:9001", base.Pools[0].Listen; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "no", base.ExtraGlobalConfig["daemonize"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if clone.IsRunning() {
+		t.Errorf("expected clone not to be running")
+	}
+}
+
+func TestProcess_CloneFreePort(t *testing.T) {
+	base := gophpfpm.NewProcess(pathToPhpFpm)
+	base.SetDatadir(basepath + "/var")
+	base.Listen = "127.0.0.1:0"
+	if _, err := base.Config(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	// the clone picks its own free port
+	clone := base.Clone()
+	if want, have := "127.0.0.1:0", clone.Listen; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if _, address := clone.Address(); address != "127.0.0.1:0" {
+		t.Errorf("expected no free port picked for the clone, got %#v", address)
+	}
+}
+
 func TestProcess_SetPrefix(t *testing.T) {
 	path := pathToPhpFpm
 	process := gophpfpm.NewProcess(path)
-	process.SetDatadir(basepath + "/var")
+	if err := process.SetDatadir(basepath + "/var"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if want, have := basepath+"/var/phpfpm.conf", process.ConfigFile; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
 	if want, have := basepath+"/var/phpfpm.pid", process.PidFile; want != have {
 		t.Errorf("expected %#v, got %#v", want, have)
 	}
 	if want, have := basepath+"/var/phpfpm.error_log", process.ErrorLog; want != have {
 		t.Errorf("expected %#v, got %#v", want, have)
 	}
+	if want, have := basepath+"/var/phpfpm.slow_log", process.SlowLog; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := basepath+"/var/phpfpm.access_log", process.AccessLog; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
 	if want, have := basepath+"/var/phpfpm.sock", process.Listen; want != have {
 		t.Errorf("expected %#v, got %#v", want, have)
 	}
 }
 
+func TestProcess_SetDatadirNamed(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	if err := process.SetDatadirNamed(basepath+"/var", "tenant1"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if want, have := basepath+"/var/tenant1.conf", process.ConfigFile; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := basepath+"/var/tenant1.pid", process.PidFile; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := basepath+"/var/tenant1.error_log", process.ErrorLog; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := basepath+"/var/tenant1.slow_log", process.SlowLog; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := basepath+"/var/tenant1.access_log", process.AccessLog; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := basepath+"/var/tenant1.sock", process.Listen; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_SetPrefixInvalid(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	if err := process.SetDatadir(basepath + "/not-exists"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if err := process.SetDatadir(basepath + "/var/www/index.php"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if want, have := "", process.Listen; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
 func TestProcess_Address(t *testing.T) {
 	var network, address string
 	process := &gophpfpm.Process{}
@@ -93,46 +320,3046 @@ func TestProcess_Address(t *testing.T) {
 
 }
 
-func TestProcess_StartStop(t *testing.T) {
-	path := pathToPhpFpm
-	process := gophpfpm.NewProcess(path)
+func TestProcess_ListenAddr(t *testing.T) {
+	tests := []struct {
+		listen  string
+		network string
+		address string
+	}{
+		{"127.0.0.1:9000", "tcp", "127.0.0.1:9000"},
+		{"[::1]:9000", "tcp", "[::1]:9000"},
+		{"9000", "tcp", ":9000"},
+		{"/path/to/hello.sock", "unix", "/path/to/hello.sock"},
+		{"myhost", "unix", "myhost"},
+	}
+	for _, test := range tests {
+		process := &gophpfpm.Process{}
+		process.Listen = test.listen
+		network, address := process.ListenAddr()
+		if want, have := test.network, network; want != have {
+			t.Errorf("%s: expected %#v; got %#v", test.listen, want, have)
+		}
+		if want, have := test.address, address; want != have {
+			t.Errorf("%s: expected %#v; got %#v", test.listen, want, have)
+		}
+	}
+}
+
+func TestProcess_AddressAmbiguous(t *testing.T) {
+	tests := []struct {
+		listen  string
+		network string
+		address string
+	}{
+		{"localhost:9000", "tcp", "localhost:9000"},
+		{"php.example.com:9000", "tcp", "php.example.com:9000"},
+		{"db.sock:9000", "tcp", "db.sock:9000"},
+		{"9000", "tcp", ":9000"},
+		{"127.0.0.1:9000", "tcp", "127.0.0.1:9000"},
+		{"myhost", "unix", "myhost"},
+		{"myhost:", "unix", "myhost:"},
+		{"myhost:http", "unix", "myhost:http"},
+		{"myhost:99999", "unix", "myhost:99999"},
+		{"99999", "unix", "99999"},
+		{":9000", "unix", ":9000"},
+		{"::1", "unix", "::1"},
+		{"hello:9000.sock", "unix", "hello:9000.sock"},
+		{"var/run/php:9000", "unix", "var/run/php:9000"},
+		{"./localhost:9000", "unix", "./localhost:9000"},
+	}
+	for _, test := range tests {
+		process := &gophpfpm.Process{}
+		process.Listen = test.listen
+		network, address := process.Address()
+		if want, have := test.network, network; want != have {
+			t.Errorf("%s: expected %#v; got %#v", test.listen, want, have)
+		}
+		if want, have := test.address, address; want != have {
+			t.Errorf("%s: expected %#v; got %#v", test.listen, want, have)
+		}
+	}
+}
+
+func TestProcess_ConfigListenPort(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
 	process.SetDatadir(basepath + "/var")
-	process.User = username
-	process.SaveConfig(basepath + "/etc/test.startstop.conf")
+	process.Listen = "65535"
+	if _, err := process.Config(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	process.Listen = "99999"
+	if _, err := process.Config(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
 
-	if err := process.Start(); err != nil {
+func TestProcess_AddressIPv6(t *testing.T) {
+	tests := []struct {
+		listen  string
+		network string
+		address string
+	}{
+		{"[::1]:9000", "tcp", "[::1]:9000"},
+		{"[::]:9000", "tcp", "[::]:9000"},
+		{"[fe80::1:2]:12345", "tcp", "[fe80::1:2]:12345"},
+		{"[::ffff:127.0.0.1]:9000", "tcp", "[::ffff:127.0.0.1]:9000"},
+		{"/path/to/[::1]:9000.sock", "unix", "/path/to/[::1]:9000.sock"},
+	}
+	for _, test := range tests {
+		process := &gophpfpm.Process{}
+		process.Listen = test.listen
+		network, address := process.Address()
+		if want, have := test.network, network; want != have {
+			t.Errorf("%s: expected %#v; got %#v", test.listen, want, have)
+		}
+		if want, have := test.address, address; want != have {
+			t.Errorf("%s: expected %#v; got %#v", test.listen, want, have)
+		}
+	}
+}
+
+func TestProcess_Config(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	f, err := process.Config()
+	if err != nil {
 		t.Errorf("unexpected error: %s", err.Error())
 		return
 	}
+	if want, have := "dynamic", f.Section("www").Key("pm").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "5", f.Section("www").Key("pm.max_children").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "2", f.Section("www").Key("pm.start_servers").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "1", f.Section("www").Key("pm.min_spare_servers").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "3", f.Section("www").Key("pm.max_spare_servers").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
 
-	go func() {
-		// do something that needs phpfpm
-		// ...
-		time.Sleep(time.Millisecond * 50)
-		if err := process.Stop(); err != nil {
-			panic(err)
-		}
-	}()
+	process.MaxChildren = 20
+	process.StartServers = 4
+	process.MinSpareServers = 2
+	process.MaxSpareServers = 6
 
-	if err := process.Wait(); err != nil {
-		t.Errorf("unexpected error: %#v", err.Error())
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "20", f.Section("www").Key("pm.max_children").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "4", f.Section("www").Key("pm.start_servers").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "2", f.Section("www").Key("pm.min_spare_servers").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "6", f.Section("www").Key("pm.max_spare_servers").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
 	}
 }
 
-func ExampleProcess() {
+func TestProcess_ConfigProcessManager(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	process.ProcessManager = gophpfpm.PMStatic
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "static", f.Section("www").Key("pm").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "5", f.Section("www").Key("pm.max_children").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if f.Section("www").HasKey("pm.start_servers") {
+		t.Errorf("unexpected pm.start_servers for static process manager")
+	}
+
+	process.ProcessManager = gophpfpm.PMOndemand
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "ondemand", f.Section("www").Key("pm").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "5", f.Section("www").Key("pm.max_children").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if !f.Section("www").HasKey("pm.process_idle_timeout") {
+		t.Errorf("expected pm.process_idle_timeout for ondemand process manager")
+	}
+	if f.Section("www").HasKey("pm.max_spare_servers") {
+		t.Errorf("unexpected pm.max_spare_servers for ondemand process manager")
+	}
+
+	process.ProcessManager = "foobar"
+	if _, err = process.Config(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if err = process.SaveConfig(basepath + "/etc/test.pm.conf"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
 
+func TestProcess_ConfigUserGroup(t *testing.T) {
 	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if f.Section("www").HasKey("user") {
+		t.Errorf("unexpected user key")
+	}
+	if f.Section("www").HasKey("group") {
+		t.Errorf("unexpected group key")
+	}
+
+	process.User = "www-data"
+	process.Group = "www-data"
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "www-data", f.Section("www").Key("user").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "www-data", f.Section("www").Key("group").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
 
-	// SetDatadir equals to running these 3 settings:
-	// process.PidFile  = basepath + "/phpfpm.pid"
-	// process.ErrorLog = basepath + "/phpfpm.error_log"
-	// process.Listen   = basepath + "/phpfpm.sock"
+func TestProcess_ConfigListenPermission(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
 	process.SetDatadir(basepath + "/var")
-	process.User = username
+	process.ListenOwner = "nginx"
+	process.ListenGroup = "www-data"
+	process.ListenMode = "0660"
 
-	// save the config file to basepath + "/etc/php-fpm.conf"
-	process.SaveConfig(basepath + "/etc/example.conf")
-	process.Start()
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "nginx", f.Section("www").Key("listen.owner").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "www-data", f.Section("www").Key("listen.group").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "0660", f.Section("www").Key("listen.mode").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// not used for tcp listen address
+	process.Listen = "127.0.0.1:9000"
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	for _, name := range []string{"listen.owner", "listen.group", "listen.mode"} {
+		if f.Section("www").HasKey(name) {
+			t.Errorf("unexpected %s for tcp listen address", name)
+		}
+	}
+}
+
+func TestProcess_ConfigAllowedClients(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.Listen = "127.0.0.1:9000"
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if f.Section("www").HasKey("listen.allowed_clients") {
+		t.Errorf("unexpected listen.allowed_clients")
+	}
+
+	process.AllowedClients = []string{"127.0.0.1", "192.168.1.10"}
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "127.0.0.1,192.168.1.10", f.Section("www").Key("listen.allowed_clients").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// not used for unix socket
+	process.Listen = basepath + "/var/phpfpm.sock"
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if f.Section("www").HasKey("listen.allowed_clients") {
+		t.Errorf("unexpected listen.allowed_clients for unix socket")
+	}
+}
+
+func TestProcess_ConfigListenBacklog(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if f.Section("www").HasKey("listen.backlog") {
+		t.Errorf("unexpected listen.backlog if not set")
+	}
+
+	for _, backlog := range []int{-1, 1024} {
+		process.ListenBacklog = backlog
+		if f, err = process.Config(); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if want, have := strconv.Itoa(backlog), f.Section("www").Key("listen.backlog").String(); want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+	}
+
+	process.ListenBacklog = -2
+	if _, err := process.Config(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestProcess_ConfigLimitExtensions(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if f.Section("www").HasKey("security.limit_extensions") {
+		t.Errorf("unexpected security.limit_extensions if not set")
+	}
+
+	process.LimitExtensions = []string{".php", ".phtml"}
+	if f, err = process.Config(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := ".php .phtml", f.Section("www").Key("security.limit_extensions").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_ConfigStatusPing(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if f.Section("www").HasKey("pm.status_path") {
+		t.Errorf("unexpected pm.status_path")
+	}
+	if f.Section("www").HasKey("ping.path") {
+		t.Errorf("unexpected ping.path")
+	}
+
+	process.StatusPath = "/status"
+	process.PingPath = "/ping"
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "/status", f.Section("www").Key("pm.status_path").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "/ping", f.Section("www").Key("ping.path").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_ConfigRequestTimeout(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	for _, name := range []string{"request_terminate_timeout", "request_slowlog_timeout"} {
+		if f.Section("www").HasKey(name) {
+			t.Errorf("unexpected %s", name)
+		}
+	}
+
+	process.SlowLog = ""
+	process.RequestTerminateTimeout = time.Minute
+	process.RequestSlowlogTimeout = time.Millisecond * 1500
+	if _, err = process.Config(); err == nil {
+		t.Errorf("expected error for request_slowlog_timeout without slowlog, got nil")
+	}
+
+	process.SlowLog = basepath + "/var/phpfpm.slow_log"
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "60s", f.Section("www").Key("request_terminate_timeout").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "2s", f.Section("www").Key("request_slowlog_timeout").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := basepath+"/var/phpfpm.slow_log", f.Section("www").Key("slowlog").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_ConfigAccessLog(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := basepath+"/var/phpfpm.access_log", f.Section("www").Key("access.log").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if f.Section("www").HasKey("access.format") {
+		t.Errorf("unexpected access.format")
+	}
+
+	process.AccessFormat = "%R - %u %t \"%m %r\" %s"
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := `"%R - %u %t \"%m %r\" %s"`, f.Section("www").Key("access.format").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	process.AccessFormat = "%R\n%s"
+	if _, err := process.Config(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	process.AccessFormat = "%R - %u %t \"%m %r\" %s"
+
+	process.AccessLog = ""
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if f.Section("www").HasKey("access.log") {
+		t.Errorf("unexpected access.log")
+	}
+}
+
+func TestProcess_ConfigCatchWorkersOutput(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if f.Section("www").HasKey("catch_workers_output") {
+		t.Errorf("unexpected catch_workers_output")
+	}
+
+	process.CatchWorkersOutput = true
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "yes", f.Section("www").Key("catch_workers_output").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_ConfigProcessIdleTimeout(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	process.ProcessManager = gophpfpm.PMOndemand
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "10s", f.Section("www").Key("pm.process_idle_timeout").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	process.ProcessIdleTimeout = time.Minute
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "60s", f.Section("www").Key("pm.process_idle_timeout").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	process.ProcessManager = gophpfpm.PMDynamic
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "60s", f.Section("www").Key("pm.process_idle_timeout").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	process.ProcessManager = gophpfpm.PMStatic
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if f.Section("www").HasKey("pm.process_idle_timeout") {
+		t.Errorf("unexpected pm.process_idle_timeout for static process manager")
+	}
+}
+
+func TestProcess_ConfigMaxRequests(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if f.Section("www").HasKey("pm.max_requests") {
+		t.Errorf("unexpected pm.max_requests")
+	}
+
+	process.MaxRequests = 500
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "500", f.Section("www").Key("pm.max_requests").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_ConfigRlimit(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if f.Section("www").HasKey("rlimit_files") {
+		t.Errorf("unexpected rlimit_files")
+	}
+	if f.Section("www").HasKey("rlimit_core") {
+		t.Errorf("unexpected rlimit_core")
+	}
+
+	process.RlimitFiles = 65536
+	process.RlimitCore = 1024
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "65536", f.Section("www").Key("rlimit_files").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "1024", f.Section("www").Key("rlimit_core").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	process.RlimitCore = -1
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "unlimited", f.Section("www").Key("rlimit_core").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	process.RlimitCore = -2
+	if _, err := process.Config(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestProcess_ConfigChroot(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if f.Section("www").HasKey("chroot") {
+		t.Errorf("unexpected chroot")
+	}
+	if f.Section("www").HasKey("chdir") {
+		t.Errorf("unexpected chdir")
+	}
+
+	process.Chroot = "/srv/jail"
+	process.Chdir = "/www"
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "/srv/jail", f.Section("www").Key("chroot").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "/www", f.Section("www").Key("chdir").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_ConfigKeepEnv(t *testing.T) {
+	// php-fpm clears the environment unless told otherwise,
+	// also for a zero value process and pool literals
+	for _, process := range []*gophpfpm.Process{
+		gophpfpm.NewProcess(pathToPhpFpm),
+		&gophpfpm.Process{},
+	} {
+		process.SetDatadir(basepath + "/var")
+		process.Pools = []gophpfpm.Pool{{PoolName: "other", Listen: "127.0.0.1:9001"}}
+		f, err := process.Config()
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		for _, name := range []string{"www", "other"} {
+			if f.Section(name).HasKey("clear_env") {
+				t.Errorf("%s: unexpected clear_env %#v", name, f.Section(name).Key("clear_env").String())
+			}
+		}
+	}
+
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.KeepEnv = true
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "no", f.Section("www").Key("clear_env").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_ConfigEmergencyRestart(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	for _, name := range []string{
+		"emergency_restart_threshold",
+		"emergency_restart_interval",
+		"process_control_timeout",
+	} {
+		if f.Section("global").HasKey(name) {
+			t.Errorf("unexpected %s", name)
+		}
+	}
+
+	process.EmergencyRestartThreshold = 10
+	process.EmergencyRestartInterval = time.Minute
+	process.ProcessControlTimeout = time.Second * 10
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "10", f.Section("global").Key("emergency_restart_threshold").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "60s", f.Section("global").Key("emergency_restart_interval").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "10s", f.Section("global").Key("process_control_timeout").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_ConfigLogLevel(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if f.Section("global").HasKey("log_level") {
+		t.Errorf("unexpected log_level")
+	}
+
+	process.LogLevel = "debug"
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "debug", f.Section("global").Key("log_level").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_ConfigString(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	str, err := process.ConfigString()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	f, err := ini.Load([]byte(str))
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := basepath+"/var/phpfpm.pid", f.Section("global").Key("pid").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := basepath+"/var/phpfpm.sock", f.Section("www").Key("listen").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	process.Listen = ""
+	if _, err := process.ConfigString(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestProcess_WriteConfigTo(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+
+	var buf bytes.Buffer
+	n, err := process.WriteConfigTo(&buf)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := int64(buf.Len()), n; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	str, err := process.ConfigString()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := str, buf.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	process.Listen = ""
+	buf.Reset()
+	if _, err := process.WriteConfigTo(&buf); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if want, have := 0, buf.Len(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_ConfigExtra(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.ExtraGlobalConfig = map[string]string{
+		"emergency_restart_threshold": "10",
+	}
+	process.ExtraPoolConfig = map[string]string{
+		"pm.max_children":           "50",
+		"request_terminate_timeout": "30s",
+		"catch_workers_output":      "yes",
+	}
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "10", f.Section("global").Key("emergency_restart_threshold").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "50", f.Section("www").Key("pm.max_children").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "30s", f.Section("www").Key("request_terminate_timeout").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "yes", f.Section("www").Key("catch_workers_output").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// newline would inject directives to the config
+	for _, extra := range []map[string]string{
+		{"slowlog": "/tmp/slow.log\nlisten = /tmp/other.sock"},
+		{"slowlog = /tmp/slow.log\nlisten": "/tmp/other.sock"},
+	} {
+		process.ExtraGlobalConfig, process.ExtraPoolConfig = extra, nil
+		if _, err := process.Config(); err == nil {
+			t.Errorf("%#v: expected error in ExtraGlobalConfig, got nil", extra)
+		}
+		process.ExtraGlobalConfig, process.ExtraPoolConfig = nil, extra
+		if _, err := process.Config(); err == nil {
+			t.Errorf("%#v: expected error in ExtraPoolConfig, got nil", extra)
+		}
+	}
+}
+
+func TestProcess_ConfigEnv(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.Env = map[string]string{
+		"APP_ENV":    "production",
+		"APP_SECRET": "hello",
+		"APP_DEBUG":  "0",
+	}
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	var envKeys []string
+	for _, key := range f.Section("www").Keys() {
+		if strings.HasPrefix(key.Name(), "env[") {
+			envKeys = append(envKeys, key.Name()+"="+key.String())
+		}
+	}
+	if want, have := []string{
+		"env[APP_DEBUG]=0",
+		"env[APP_ENV]=production",
+		"env[APP_SECRET]=hello",
+	}, envKeys; !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_ConfigEnvQuoted(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.Env = map[string]string{
+		"APP_SECRET": `abc;def#g"h$i\j`,
+		"APP_ENV":    "production",
+	}
+
+	var buf bytes.Buffer
+	if _, err := process.WriteConfigTo(&buf); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	str := buf.String()
+	for _, line := range []string{
+		`env\[APP_ENV\] *= production\n`,
+		`env\[APP_SECRET\] *= "abc;def#g\\"h\\\$i\\\\j"\n`,
+	} {
+		if !regexp.MustCompile(line).MatchString(str) {
+			t.Errorf("expected %#v in config, got %s", line, str)
+		}
+	}
+
+	// cannot be quoted for php-fpm
+	for _, value := range []string{"abc\ndef", "abc`def"} {
+		process.Env = map[string]string{"APP_SECRET": value}
+		if _, err := process.Config(); err == nil {
+			t.Errorf("%#v: expected error, got nil", value)
+		}
+	}
+}
+
+func TestProcess_ConfigPHPValue(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.PHPAdminValue = map[string]string{
+		"memory_limit": "128M",
+		"error_log":    "/tmp/php.error_log",
+	}
+	process.PHPValue = map[string]string{
+		"max_execution_time": "30",
+	}
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	var keys []string
+	for _, key := range f.Section("www").Keys() {
+		if strings.HasPrefix(key.Name(), "php_") {
+			keys = append(keys, key.Name()+"="+key.String())
+		}
+	}
+	if want, have := []string{
+		"php_admin_value[error_log]=/tmp/php.error_log",
+		"php_admin_value[memory_limit]=128M",
+		"php_value[max_execution_time]=30",
+	}, keys; !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_ConfigPHPValueQuoted(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.PHPAdminValue = map[string]string{
+		"error_log": "/tmp/php#1.error_log",
+	}
+	process.PHPValue = map[string]string{
+		"date.timezone":        "Europe/Paris",
+		"error_prepend_string": `<b>"error";</b>`,
+	}
+
+	var buf bytes.Buffer
+	if _, err := process.WriteConfigTo(&buf); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	str := buf.String()
+	for _, line := range []string{
+		`php_admin_value\[error_log\] *= "/tmp/php#1.error_log"\n`,
+		`php_value\[date.timezone\] *= Europe/Paris\n`,
+		`php_value\[error_prepend_string\] *= "<b>\\"error\\";</b>"\n`,
+	} {
+		if !regexp.MustCompile(line).MatchString(str) {
+			t.Errorf("expected %#v in config, got %s", line, str)
+		}
+	}
+
+	// cannot be quoted for php-fpm
+	process.PHPAdminValue = map[string]string{"error_log": "abc`def"}
+	if _, err := process.Config(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	process.PHPAdminValue = nil
+	process.PHPValue = map[string]string{"error_prepend_string": "abc\ndef"}
+	if _, err := process.Config(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestProcess_Validate(t *testing.T) {
+	valid := func() *gophpfpm.Process {
+		process := gophpfpm.NewProcess(pathToPhpFpm)
+		process.SetDatadir(basepath + "/var")
+		return process
+	}
+
+	if err := valid().Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	invalids := map[string]func(process *gophpfpm.Process){
+		"empty listen":    func(process *gophpfpm.Process) { process.Listen = "" },
+		"empty pid file":  func(process *gophpfpm.Process) { process.PidFile = "" },
+		"empty error log": func(process *gophpfpm.Process) { process.ErrorLog = "" },
+		"unknown log level": func(process *gophpfpm.Process) {
+			process.LogLevel = "verbose"
+		},
+		"negative max children": func(process *gophpfpm.Process) {
+			process.MaxChildren = -1
+		},
+		"start servers below min spare": func(process *gophpfpm.Process) {
+			process.MinSpareServers = 2
+			process.StartServers = 1
+		},
+		"start servers above max spare": func(process *gophpfpm.Process) {
+			process.StartServers = 4
+			process.MaxSpareServers = 3
+		},
+		"min spare above max spare": func(process *gophpfpm.Process) {
+			process.MinSpareServers = 5
+			process.MaxSpareServers = 3
+		},
+		"max spare above max children": func(process *gophpfpm.Process) {
+			process.MaxChildren = 2
+		},
+		"unknown process manager": func(process *gophpfpm.Process) {
+			process.ProcessManager = "foobar"
+		},
+	}
+	for name, modify := range invalids {
+		process := valid()
+		modify(process)
+		if err := process.Validate(); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+		if err := process.SaveConfig(basepath + "/etc/test.validate.conf"); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestProcess_ValidateSpareServers(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.MinSpareServers = 5
+	process.MaxSpareServers = 3
+	err := process.Validate()
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	} else if want, have := "pm.min_spare_servers (5) must be <= pm.max_spare_servers (3)", err.Error(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	process.MinSpareServers = 1
+	process.MaxChildren = 2
+	err = process.Validate()
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	} else if want, have := "pm.max_spare_servers (3) must be <= pm.max_children (2)", err.Error(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+var updateGolden = flag.Bool("update", false, "update the golden files in _test/golden")
+
+func TestProcess_ConfigGolden(t *testing.T) {
+	process := gophpfpm.NewProcess("/usr/sbin/php-fpm")
+	process.PidFile = "/var/run/phpfpm.pid"
+	process.ErrorLog = "/var/log/phpfpm.error_log"
+	process.LogLevel = "notice"
+	process.Listen = "127.0.0.1:9000"
+	process.AllowedClients = []string{"127.0.0.1"}
+	process.User = "www-data"
+	process.Group = "www-data"
+	process.StatusPath = "/status"
+	process.PingPath = "/ping"
+	process.Env = map[string]string{
+		"PATH":     "/usr/bin:/bin",
+		"APP_ENV":  "production",
+		"TMPDIR":   "/tmp",
+		"HOSTNAME": "php",
+	}
+	process.PHPAdminValue = map[string]string{
+		"memory_limit": "128M",
+		"error_log":    "/var/log/php.error_log",
+	}
+	process.PHPValue = map[string]string{
+		"max_execution_time": "30",
+		"display_errors":     "off",
+	}
+	process.ExtraPoolConfig = map[string]string{
+		"security.limit_extensions": ".php",
+		"pm.max_children":           "10",
+	}
+	process.ExtraGlobalConfig = map[string]string{
+		"systemd_interval": "0",
+		"daemonize":        "no",
+	}
+	other := gophpfpm.NewPool("other", "/var/run/other.sock")
+	other.ProcessManager = gophpfpm.PMOndemand
+	other.KeepEnv = true
+	other.Env = map[string]string{"B": "2", "A": "1"}
+	process.Pools = []gophpfpm.Pool{other}
+
+	golden := basepath + "/golden/config.golden"
+	for i := 0; i < 10; i++ {
+		var buf bytes.Buffer
+		if _, err := process.WriteConfigTo(&buf); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if *updateGolden {
+			if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		// the blank line after the last section
+		// depends on the go-ini version
+		if want, have := strings.TrimRight(string(want), "\n"), strings.TrimRight(buf.String(), "\n"); want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+			return
+		}
+	}
+}
+
+func TestProcess_ConfigComments(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	if want, have := true, process.ConfigComments; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	str, err := process.ConfigString()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "; managed by gophpfpm\n", str; !strings.HasPrefix(have, want) {
+		t.Errorf("expected prefix %#v, got %#v", want, have)
+	}
+	if want, have := "; maximum number of child processes\npm.max_children", str; !strings.Contains(have, want) {
+		t.Errorf("expected %#v in %#v", want, have)
+	}
+
+	process.ConfigComments = false
+	if str, err = process.ConfigString(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if strings.Contains(str, ";") {
+		t.Errorf("expected no comments, got %#v", str)
+	}
+}
+
+func TestProcess_SaveConfig(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	err := process.SaveConfig(basepath + "/etc/not-exists/test.saveconfig.conf")
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestProcess_SaveConfigFailed(t *testing.T) {
+	configFile := basepath + "/etc/test.saveconfigfailed.conf"
+	ioutil.WriteFile(configFile, []byte("; existing config\n"), 0644)
+
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.User = username
+
+	// passes Validate, but no free port can be picked
+	process.Listen = "192.0.2.1:0"
+	if err := process.SaveConfig(configFile); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	b, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "; existing config\n", string(b); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_SaveConfigDefault(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	if err := process.SaveConfig(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	process.SetDatadirNamed(basepath+"/var", "test.saveconfigdefault")
+	process.User = username
+	if err := process.SaveConfig(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, err := os.Stat(basepath + "/var/test.saveconfigdefault.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	if err := process.SaveConfig(basepath+"/etc/a.conf", basepath+"/etc/b.conf"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if want, have := basepath+"/var/test.saveconfigdefault.conf", process.ConfigFile; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_AppendConfig(t *testing.T) {
+	configFile := basepath + "/etc/test.appendconfig.conf"
+	ioutil.WriteFile(configFile, []byte(`; hand-written config
+[global]
+pid = /tmp/hello.pid
+error_log = /tmp/hello.error_log
+
+[www]
+listen = /tmp/old.sock
+
+; another pool
+[legacy]
+listen = /tmp/legacy.sock
+`), 0644)
+
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	if err := process.AppendConfig(configFile); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := configFile, process.ConfigFile; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	f, err := ini.Load(configFile)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "/tmp/hello.pid", f.Section("global").Key("pid").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := process.Listen, f.Section("www").Key("listen").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "/tmp/legacy.sock", f.Section("legacy").Key("listen").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	b, _ := ioutil.ReadFile(configFile)
+	if !strings.Contains(string(b), "another pool") {
+		t.Errorf("expected comment to be kept, got %s", b)
+	}
+
+	if err := process.AppendConfig(basepath + "/etc/not-exists.conf"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	process.Listen = ""
+	if err := process.AppendConfig(configFile); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestProcess_LoadConfig(t *testing.T) {
+	configFile := basepath + "/etc/test.loadconfig.conf"
+	ioutil.WriteFile(configFile, []byte(`[global]
+pid = /tmp/hello.pid
+error_log = /tmp/hello.error_log
+emergency_restart_threshold = 10
+emergency_restart_interval = 1m
+events.mechanism = epoll
+
+[www]
+listen = 127.0.0.1:9000
+user = nobody
+pm = static
+pm.max_children = 10
+pm.max_requests = 500
+request_terminate_timeout = 30s
+catch_workers_output = yes
+clear_env = no
+rlimit_files = 4096
+rlimit_core = unlimited
+security.limit_extensions = .php  .phtml
+listen.backlog = 1024
+decorate_workers_output = no
+env[APP_ENV] = production
+php_admin_value[memory_limit] = 128M
+php_value[max_execution_time] = 30
+`), 0644)
+
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	if err := process.LoadConfig(configFile); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := configFile, process.ConfigFile; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "/tmp/hello.pid", process.PidFile; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "/tmp/hello.error_log", process.ErrorLog; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 10, process.EmergencyRestartThreshold; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := time.Minute, process.EmergencyRestartInterval; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "127.0.0.1:9000", process.Listen; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "nobody", process.User; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := gophpfpm.PMStatic, process.ProcessManager; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 10, process.MaxChildren; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 500, process.MaxRequests; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := time.Second*30, process.RequestTerminateTimeout; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := true, process.CatchWorkersOutput; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := true, process.KeepEnv; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 4096, process.RlimitFiles; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := -1, process.RlimitCore; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 1024, process.ListenBacklog; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := []string{".php", ".phtml"}, process.LimitExtensions; !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "no", process.ExtraPoolConfig["decorate_workers_output"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "production", process.Env["APP_ENV"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "128M", process.PHPAdminValue["memory_limit"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "30", process.PHPValue["max_execution_time"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// unknown keys should round trip
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "epoll", f.Section("global").Key("events.mechanism").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "30s", f.Section("www").Key("request_terminate_timeout").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "no", f.Section("www").Key("decorate_workers_output").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// invalid number
+	ioutil.WriteFile(configFile, []byte(`[www]
+pm.max_children = many
+`), 0644)
+	if err := process.LoadConfig(configFile); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	// quoted values should round trip
+	saved := gophpfpm.NewProcess(pathToPhpFpm)
+	saved.SetDatadir(basepath + "/var")
+	saved.AccessFormat = `%R - %u "%m %r" $x\y;z`
+	saved.Env = map[string]string{"APP_KEY": `x;y$z\w`, "QUOTE": `a"b`}
+	saved.PHPAdminValue = map[string]string{"error_prepend_string": `"<b>$a\</b>";`}
+	saved.PHPValue = map[string]string{"include_path": `.;C:\php\pear`}
+	str, err := saved.ConfigString()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	ioutil.WriteFile(configFile, []byte(str), 0644)
+	loaded := gophpfpm.NewProcess(pathToPhpFpm)
+	if err := loaded.LoadConfig(configFile); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := saved.AccessFormat, loaded.AccessFormat; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := saved.Env, loaded.Env; !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := saved.PHPAdminValue, loaded.PHPAdminValue; !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := saved.PHPValue, loaded.PHPValue; !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if resaved, err := loaded.ConfigString(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	} else if want, have := str, resaved; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_StartStop(t *testing.T) {
+	path := pathToPhpFpm
+	process := gophpfpm.NewProcess(path)
+	process.SetDatadir(basepath + "/var")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.startstop.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	go func() {
+		// do something that needs phpfpm
+		// ...
+		time.Sleep(time.Millisecond * 50)
+		if err := process.Stop(); err != nil {
+			panic(err)
+		}
+	}()
+
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %#v", err.Error())
+	}
+}
+
+func TestProcess_StartTwice(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.starttwice")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.starttwice.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	pid := process.Pid()
+
+	// the running process should be kept
+	if _, _, err := process.Start(); err != gophpfpm.ErrAlreadyStarted {
+		t.Errorf("expected ErrAlreadyStarted, got %#v", err)
+	}
+	if want, have := pid, process.Pid(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if !process.IsRunning() {
+		t.Errorf("expected process to be running")
+	}
+
+	// it can be started again once exited
+	process.Stop()
+	process.Wait()
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	process.Stop()
+	process.Wait()
+}
+
+func TestProcess_StartNotBlocking(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.startnotblocking")
+	process.User = username
+	process.StdoutTo = ioutil.Discard
+	process.StderrTo = ioutil.Discard
+
+	// never logs to stderr, as ErrorLog is a file
+	process.ReadinessMode = gophpfpm.ReadinessLog
+	process.StartTimeout = time.Second * 10
+	if err := process.SaveConfig(basepath + "/etc/test.startnotblocking.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	started := make(chan error, 1)
+	go func() {
+		_, _, err := process.Start()
+		started <- err
+	}()
+	if !waitFor(time.Second*5, func() bool {
+		_, err := process.ReadPidFile()
+		return err == nil
+	}) {
+		t.Errorf("expected process to be starting")
+		return
+	}
+
+	// the accessors return while Start waits, and
+	// report the process as not started yet
+	accessed := make(chan struct{})
+	go func() {
+		defer close(accessed)
+		if process.IsRunning() {
+			t.Errorf("expected process not to be running")
+		}
+		if want, have := -1, process.Pid(); want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+		process.StderrTail(0)
+		process.Done()
+		if _, _, err := process.Start(); err != gophpfpm.ErrAlreadyStarted {
+			t.Errorf("expected ErrAlreadyStarted, got %#v", err)
+		}
+		if _, _, err := process.Restart(); err != gophpfpm.ErrAlreadyStarted {
+			t.Errorf("expected ErrAlreadyStarted, got %#v", err)
+		}
+	}()
+	select {
+	case <-accessed:
+	case <-time.After(time.Second):
+		t.Errorf("expected the accessors not to wait for Start")
+	}
+
+	// stop ends the start
+	process.Stop()
+	select {
+	case err := <-started:
+		if err == nil {
+			t.Errorf("expected error, got nil")
+		}
+	case <-time.After(time.Second * 5):
+		t.Errorf("timed out waiting for Start to return")
+	}
+	process.Wait()
+}
+
+func TestProcess_Pid(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	if want, have := -1, process.Pid(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	process.SetDatadir(basepath + "/var")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.pid.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if pid := process.Pid(); pid <= 0 {
+		t.Errorf("expected a valid pid, got %#v", pid)
+	}
+	process.Stop()
+	process.Wait()
+	if want, have := -1, process.Pid(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_ReadPidFile(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.PidFile = basepath + "/var/test.readpidfile.pid"
+	os.Remove(process.PidFile)
+
+	if _, err := process.ReadPidFile(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	ioutil.WriteFile(process.PidFile, []byte("hello"), 0644)
+	if _, err := process.ReadPidFile(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	ioutil.WriteFile(process.PidFile, []byte("12345\n"), 0644)
+	pid, err := process.ReadPidFile()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if want, have := 12345, pid; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	os.Remove(process.PidFile)
+}
+
+func TestProcess_Cleanup(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.cleanup")
+	os.Remove(process.Listen)
+	os.Remove(process.PidFile)
+
+	// nothing to clean
+	if err := process.Cleanup(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	// socket still accepting connection
+	l, err := net.Listen("unix", process.Listen)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := process.Cleanup(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if _, err := os.Stat(process.Listen); err != nil {
+		t.Errorf("expected socket to be kept, got %s", err.Error())
+	}
+
+	// stale socket
+	l.Close()
+	if err := process.Cleanup(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if _, err := os.Stat(process.Listen); !os.IsNotExist(err) {
+		t.Errorf("expected socket to be removed, got %#v", err)
+	}
+
+	// not a socket
+	ioutil.WriteFile(process.Listen, []byte("user data"), 0644)
+	if err := process.Cleanup(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if _, err := os.Stat(process.Listen); err != nil {
+		t.Errorf("expected file to be kept, got %s", err.Error())
+	}
+	os.Remove(process.Listen)
+
+	// pid file of a running process
+	ioutil.WriteFile(process.PidFile, []byte(strconv.Itoa(os.Getpid())), 0644)
+	if err := process.Cleanup(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if _, err := os.Stat(process.PidFile); err != nil {
+		t.Errorf("expected pid file to be kept, got %s", err.Error())
+	}
+
+	// stale pid file
+	ioutil.WriteFile(process.PidFile, []byte("999999999"), 0644)
+	if err := process.Cleanup(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if _, err := os.Stat(process.PidFile); !os.IsNotExist(err) {
+		t.Errorf("expected pid file to be removed, got %#v", err)
+	}
+}
+
+func TestProcess_WaitRemoveFiles(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.removefiles")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.removefiles.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	process.Stop()
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if _, err := os.Stat(process.PidFile); !os.IsNotExist(err) {
+		t.Errorf("expected pid file to be removed, got %#v", err)
+	}
+	if _, err := os.Stat(process.Listen); !os.IsNotExist(err) {
+		t.Errorf("expected socket to be removed, got %#v", err)
+	}
+}
+
+func TestProcess_WaitKilled(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.waitkilled")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.waitkilled.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	// files are only removed after a successful
+	// Wait, and cleaned on next Start otherwise
+	process.StopWithSignal(os.Kill)
+	if err := process.Wait(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if _, err := os.Stat(process.PidFile); err != nil {
+		t.Errorf("expected pid file to be kept, got %s", err.Error())
+	}
+	if _, err := os.Stat(process.Listen); err != nil {
+		t.Errorf("expected socket to be kept, got %s", err.Error())
+	}
+	if err := process.Cleanup(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestProcess_Concurrent(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.concurrent")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.concurrent.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	// stop racing with start either stops the started
+	// process, stops it while starting or finds it not
+	// started
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		var startErr *gophpfpm.StartError
+		if _, _, err := process.Start(); err != nil &&
+			!(errors.As(err, &startErr) && startErr.Phase == gophpfpm.StartPhaseExit) {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		process.IsRunning()
+		process.Pid()
+		if err := process.Stop(); err != nil && err != gophpfpm.ErrNotStarted {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+	}()
+	wg.Wait()
+	process.Stop()
+	process.Wait()
+
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			process.IsRunning()
+			process.Pid()
+			process.Wait()
+			process.ExitCode()
+		}()
+	}
+	process.Stop()
+	wg.Wait()
+	if process.IsRunning() {
+		t.Errorf("expected process to be stopped")
+	}
+}
+
+func TestProcess_WaitContext(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.waitcontext")
+	process.User = username
+	if _, err := process.WaitContext(context.Background()); err != gophpfpm.ErrNotStarted {
+		t.Errorf("expected ErrNotStarted, got %#v", err)
+	}
+	if err := process.SaveConfig(basepath + "/etc/test.waitcontext.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if _, err := process.WaitContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded, got %#v", err)
+	}
+	if !process.IsRunning() {
+		t.Errorf("expected process to be kept running")
+	}
+
+	process.Stop()
+	state, err := process.WaitContext(context.Background())
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if state == nil || !state.Exited() {
+		t.Errorf("expected exited process state, got %#v", state)
+	}
+	if _, err := process.WaitContext(context.Background()); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestProcess_ExitCode(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.exitcode")
+	process.User = username
+	if want, have := -1, process.ExitCode(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if err := process.SaveConfig(basepath + "/etc/test.exitcode.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := -1, process.ExitCode(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	process.Stop()
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if want, have := 0, process.ExitCode(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if sig, ok := process.ExitedBySignal(); ok {
+		t.Errorf("expected no signal, got %s", sig)
+	}
+}
+
+func TestProcess_ExitCodeDaemonize(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.exitcodedaemonize")
+	process.User = username
+	process.Daemonize = true
+	if err := process.SaveConfig(basepath + "/etc/test.exitcodedaemonize.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := -1, process.ExitCode(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// exit code of the launcher, which daemonized php-fpm
+	process.Stop()
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if want, have := 0, process.ExitCode(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if sig, ok := process.ExitedBySignal(); ok {
+		t.Errorf("expected no signal, got %s", sig)
+	}
+}
+
+func TestProcess_ExitedBySignal(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.exitsignal")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.exitsignal.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	process.StopWithSignal(os.Kill)
+	process.Wait()
+	sig, ok := process.ExitedBySignal()
+	if !ok {
+		t.Errorf("expected to be terminated by signal")
+	} else if want, have := os.Kill, sig; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := -1, process.ExitCode(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	process.Cleanup()
+}
+
+func TestProcess_OnExit(t *testing.T) {
+	type exit struct {
+		state *os.ProcessState
+		err   error
+	}
+	exits := make(chan exit, 2)
+
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.onexit")
+	process.User = username
+	process.OnExit = func(state *os.ProcessState, err error) {
+		exits <- exit{state, err}
+	}
+	if err := process.SaveConfig(basepath + "/etc/test.onexit.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	process.StopWithSignal(os.Kill)
+
+	select {
+	case e := <-exits:
+		if e.state == nil {
+			t.Errorf("expected process state, got nil")
+		} else if e.state.Success() {
+			t.Errorf("expected killed process state, got %s", e.state)
+		}
+		if e.err == nil {
+			t.Errorf("expected error, got nil")
+		}
+	case <-time.After(time.Second * 5):
+		t.Errorf("timed out waiting for OnExit")
+	}
+
+	// Wait returns the same error and OnExit is
+	// not called again
+	if err := process.Wait(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if err := process.Wait(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	select {
+	case <-exits:
+		t.Errorf("expected OnExit to be called once")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestProcess_Done(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.done")
+	process.User = username
+	if process.Done() != nil {
+		t.Errorf("expected nil channel before start")
+	}
+	if err := process.SaveConfig(basepath + "/etc/test.done.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	select {
+	case <-process.Done():
+		t.Errorf("unexpected exit")
+	default:
+	}
+
+	process.Stop()
+	select {
+	case <-process.Done():
+		if process.IsRunning() {
+			t.Errorf("expected not running after done")
+		}
+	case <-time.After(time.Second * 5):
+		t.Errorf("timed out waiting for exit")
+	}
+}
+
+func TestProcess_IsRunning(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	if process.IsRunning() {
+		t.Errorf("expected not running before start")
+	}
+
+	process.SetDatadir(basepath + "/var")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.isrunning.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if !process.IsRunning() {
+		t.Errorf("expected running after start")
+	}
+	process.Stop()
+	process.Wait()
+	if process.IsRunning() {
+		t.Errorf("expected not running after wait")
+	}
+}
+
+func TestProcess_Restart(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.restart.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	// not running, should simply start
+	if _, _, err := process.Restart(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Restart(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if err := process.Stop(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestProcess_StopWithSignal(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.stopwithsignal.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if err := process.StopWithSignal(syscall.SIGQUIT); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestProcess_StopContext(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	if want, have := gophpfpm.ErrNotStarted, process.StopContext(context.Background()); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	process.SetDatadir(basepath + "/var")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.stopcontext.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := process.StopContext(ctx); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if process.IsRunning() {
+		t.Errorf("expected not running after StopContext")
+	}
+}
+
+func TestProcess_Drain(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	if err := process.Drain(context.Background()); !errors.Is(err, gophpfpm.ErrNotStarted) {
+		t.Errorf("expected ErrNotStarted, got %#v", err)
+	}
+
+	process.SetDatadirNamed(basepath+"/var", "test.drain")
+	process.User = username
+	for _, statusPath := range []string{"", "/status"} {
+		process.StatusPath = statusPath
+		if err := process.SaveConfig(); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if _, _, err := process.Start(); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		if err := process.Drain(ctx); err != nil {
+			t.Errorf("%#v: unexpected error: %s", statusPath, err.Error())
+		}
+		cancel()
+		if process.IsRunning() {
+			t.Errorf("%#v: expected not running after Drain", statusPath)
+		}
+	}
+}
+
+func TestProcess_NotStarted(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	if err := process.Stop(); !errors.Is(err, gophpfpm.ErrNotStarted) {
+		t.Errorf("expected ErrNotStarted, got %#v", err)
+	}
+	if err := process.Wait(); !errors.Is(err, gophpfpm.ErrNotStarted) {
+		t.Errorf("expected ErrNotStarted, got %#v", err)
+	}
+}
+
+func TestProcess_StartStopTCP(t *testing.T) {
+	path := pathToPhpFpm
+	process := gophpfpm.NewProcess(path)
+	process.SetDatadir(basepath + "/var")
+	process.Listen = "127.0.0.1:9123"
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.startstop.tcp.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	go func() {
+		// do something that needs phpfpm
+		// ...
+		time.Sleep(time.Millisecond * 50)
+		if err := process.Stop(); err != nil {
+			panic(err)
+		}
+	}()
+
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %#v", err.Error())
+	}
+}
+
+func TestProcess_StartPhpIni(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.User = username
+	process.PhpIni = basepath + "/etc/test.php.ini"
+	ioutil.WriteFile(process.PhpIni, []byte("date.timezone = UTC\n"), 0644)
+	if err := process.SaveConfig(basepath + "/etc/test.phpini.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	process.Stop()
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestProcess_StartExtraArgs(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.User = username
+	process.ExtraArgs = []string{"-d", "date.timezone=UTC"}
+	if err := process.SaveConfig(basepath + "/etc/test.extraargs.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	process.Stop()
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestProcess_StartAllowRunAsRoot(t *testing.T) {
+	// records the arguments instead of running php-fpm
+	execFile := basepath + "/var/test.allowrunasroot.sh"
+	argsFile := basepath + "/var/test.allowrunasroot.args"
+	if err := ioutil.WriteFile(execFile, []byte("#!/bin/sh\necho \"$@\" > "+argsFile+"\n"), 0755); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	defer os.Remove(execFile)
+	defer os.Remove(argsFile)
+
+	process := gophpfpm.NewProcess(execFile)
+	process.SetDatadirNamed(basepath+"/var", "test.allowrunasroot")
+	process.User = username
+	process.StartTimeout = time.Millisecond * 200
+	if err := process.SaveConfig(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	for _, allow := range []bool{true, false} {
+		os.Remove(argsFile)
+		process.AllowRunAsRoot = allow
+		if _, _, err := process.Start(); err == nil {
+			t.Errorf("expected error, got nil")
+		}
+		b, err := ioutil.ReadFile(argsFile)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			continue
+		}
+		args := strings.Fields(string(b))
+		passed := false
+		for _, arg := range args {
+			passed = passed || arg == "--allow-to-run-as-root"
+		}
+		if want, have := allow, passed; want != have {
+			t.Errorf("expected --allow-to-run-as-root passed to be %#v, got %#v in %#v", want, have, args)
+		}
+	}
+}
+
+func TestProcess_StartProcessEnv(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.User = username
+	process.ProcessEnv = []string{"PATH=" + os.Getenv("PATH")}
+	if err := process.SaveConfig(basepath + "/etc/test.processenv.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	process.Stop()
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestProcess_StartWorkingDir(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.WorkingDir = basepath
+	process.PidFile = "var/test.workingdir.pid"
+	process.ErrorLog = "var/test.workingdir.error_log"
+	process.Listen = "127.0.0.1:9124"
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.workingdir.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, err := os.Stat(basepath + "/var/test.workingdir.pid"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	process.Stop()
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestProcess_StartDaemonize(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.daemonize")
+	process.User = username
+	process.Daemonize = true
+	if err := process.SaveConfig(basepath + "/etc/test.daemonize.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if !process.IsRunning() {
+		t.Errorf("expected daemon to be running")
+	}
+	pid, err := process.ReadPidFile()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if want, have := pid, process.Pid(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if err := process.Stop(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if process.IsRunning() {
+		t.Errorf("expected daemon to be stopped")
+	}
+}
+
+func TestProcess_StartDaemonizeTimeout(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.daemonizetimeout")
+	process.User = username
+	process.Daemonize = true
+	process.StdoutTo = ioutil.Discard
+
+	// never logs to stderr, as ErrorLog is a file
+	process.ReadinessMode = gophpfpm.ReadinessLog
+	process.StartTimeout = time.Millisecond * 300
+	if err := process.SaveConfig(basepath + "/etc/test.daemonizetimeout.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	started := make(chan error, 1)
+	go func() {
+		_, _, err := process.Start()
+		started <- err
+	}()
+	select {
+	case err := <-started:
+		var startErr *gophpfpm.StartError
+		if !errors.As(err, &startErr) {
+			t.Errorf("expected StartError, got %#v", err)
+		} else if want, have := gophpfpm.StartPhaseTimeout, startErr.Phase; want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+	case <-time.After(time.Second * 5):
+		t.Errorf("timed out waiting for Start to return")
+		return
+	}
+	if process.IsRunning() {
+		t.Errorf("expected the daemon not ready to be killed")
+	}
+	if _, err := os.Stat(process.PidFile); !os.IsNotExist(err) {
+		t.Errorf("expected pid file to be removed, got %#v", err)
+	}
+}
+
+func TestProcess_StartOutputTo(t *testing.T) {
+	var stdoutBuf, stderrBuf bytes.Buffer
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.User = username
+	process.StdoutTo = &stdoutBuf
+	process.StderrTo = &stderrBuf
+	if err := process.SaveConfig(basepath + "/etc/test.outputto.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	stdout, stderr, err := process.Start()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if stdout != nil {
+		t.Errorf("expected nil stdout, got %#v", stdout)
+	}
+	if stderr != nil {
+		t.Errorf("expected nil stderr, got %#v", stderr)
+	}
+	process.Stop()
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestProcess_StartReadinessPollInterval(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.pollinterval")
+	process.User = username
+	process.ReadinessPollInterval = time.Millisecond * 50
+	if err := process.SaveConfig(basepath + "/etc/test.pollinterval.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if !process.IsRunning() {
+		t.Errorf("expected process to be running")
+	}
+	process.Stop()
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestProcess_StartStrictReadiness(t *testing.T) {
+	for _, mode := range []string{gophpfpm.ReadinessSocket, gophpfpm.ReadinessLog} {
+		process := gophpfpm.NewProcess(pathToPhpFpm)
+		process.SetDatadirNamed(basepath+"/var", "test.strictreadiness")
+		process.User = username
+		process.ReadinessMode = mode
+		process.StrictReadiness = true
+		if mode == gophpfpm.ReadinessLog {
+			process.ErrorLog = "/proc/self/fd/2"
+		}
+		if err := process.SaveConfig(); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if _, _, err := process.Start(); err != nil {
+			t.Errorf("%s: unexpected error: %s", mode, err.Error())
+			continue
+		}
+
+		// the pid file is ready once Start returns
+		pid, err := process.ReadPidFile()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", mode, err.Error())
+		} else if want, have := process.Pid(), pid; want != have {
+			t.Errorf("%s: expected %#v, got %#v", mode, want, have)
+		}
+		process.Stop()
+		if err := process.Wait(); err != nil {
+			t.Errorf("%s: unexpected error: %s", mode, err.Error())
+		}
+	}
+}
+
+func TestProcess_StartNoFdLeak(t *testing.T) {
+	fds := func() int {
+		entries, _ := ioutil.ReadDir("/proc/self/fd")
+		return len(entries)
+	}
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("unable to count open file descriptors")
+	}
+
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.nofdleak")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.nofdleak.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	before := fds()
+	for i := 0; i < 3; i++ {
+		stdout, stderr, err := process.Start()
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		process.Stop()
+		process.Wait()
+		stdout.Close()
+		stderr.Close()
+	}
+	if !waitFor(time.Second, func() bool { return fds() <= before }) {
+		t.Errorf("expected %d open file descriptors, got %d", before, fds())
+	}
+}
+
+func TestProcess_StartTimeoutNoGoroutineLeak(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.goroutineleak")
+	process.User = username
+	process.StdoutTo = ioutil.Discard
+	process.StderrTo = ioutil.Discard
+	process.StartTimeout = time.Millisecond * 200
+	if err := process.SaveConfig(basepath + "/etc/test.goroutineleak.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	// wait on an address php-fpm never listens to
+	process.Listen = basepath + "/var/test.goroutineleak.other.sock"
+
+	before := runtime.NumGoroutine()
+	if _, _, err := process.Start(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	process.Stop()
+	process.Wait()
+	if !waitFor(time.Second, func() bool { return runtime.NumGoroutine() <= before }) {
+		t.Errorf("expected %d goroutines, got %d", before, runtime.NumGoroutine())
+	}
+}
+
+func TestProcess_StartReadinessLog(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.ErrorLog = "/proc/self/fd/2"
+	process.User = username
+	process.ReadinessMode = gophpfpm.ReadinessLog
+	if err := process.SaveConfig(basepath + "/etc/test.readinesslog.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	_, stderr, err := process.Start()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	go io.Copy(ioutil.Discard, stderr)
+	process.Stop()
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	process.ReadinessMode = "foobar"
+	if _, _, err := process.Start(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestProcess_StartError(t *testing.T) {
+	process := gophpfpm.NewProcess(basepath + "/not-exists/php-fpm")
+	process.SetDatadirNamed(basepath+"/var", "test.starterror")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.starterror.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	_, _, err := process.Start()
+	var startErr *gophpfpm.StartError
+	if !errors.As(err, &startErr) {
+		t.Errorf("expected StartError, got %#v", err)
+	} else if want, have := gophpfpm.StartPhaseExec, startErr.Phase; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// never logs to stderr, as ErrorLog is a file
+	process.Exec = pathToPhpFpm
+	process.ReadinessMode = gophpfpm.ReadinessLog
+	process.StartTimeout = time.Millisecond * 200
+	_, stderr, err := process.Start()
+	if stderr != nil {
+		go io.Copy(ioutil.Discard, stderr)
+	}
+	defer func() {
+		process.Stop()
+		process.Wait()
+	}()
+	if !errors.As(err, &startErr) {
+		t.Errorf("expected StartError, got %#v", err)
+		return
+	}
+	if want, have := gophpfpm.StartPhaseTimeout, startErr.Phase; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if process.IsRunning() {
+		t.Errorf("expected the process not ready to be killed")
+	}
+	if want, have := "unix", startErr.Net; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := process.Listen, startErr.Addr; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if startErr.Unwrap() == nil {
+		t.Errorf("expected cause, got nil")
+	}
+}
+
+func TestProcess_StartErrorStderr(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.starterrorstderr")
+	process.User = username
+	process.StartTimeout = time.Second * 5
+
+	// php-fpm reports the invalid config to stderr and exits
+	process.ConfigFile = basepath + "/etc/test.starterrorstderr.conf"
+	ioutil.WriteFile(process.ConfigFile, []byte("[global]\nhello world\n"), 0644)
+	start := time.Now()
+	stdout, stderr, err := process.Start()
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		process.Stop()
+		process.Wait()
+		return
+	}
+	if elapsed := time.Since(start); elapsed >= process.StartTimeout {
+		t.Errorf("expected early exit to be detected, took %s", elapsed)
+	}
+	if stdout != nil || stderr != nil {
+		t.Errorf("expected no pipes on error, got %#v and %#v", stdout, stderr)
+	}
+	var startErr *gophpfpm.StartError
+	if !errors.As(err, &startErr) {
+		t.Errorf("expected StartError, got %#v", err)
+	} else if want, have := gophpfpm.StartPhaseExit, startErr.Phase; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if !strings.Contains(err.Error(), "ERROR") {
+		t.Errorf("expected error to contain stderr of php-fpm, got %#v", err.Error())
+	}
+}
+
+func TestProcess_StartErrorDaemonize(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.starterrordaemonize")
+	process.User = username
+	process.Daemonize = true
+	process.StartTimeout = time.Second * 5
+
+	// the launcher reports the invalid config and exits
+	process.ConfigFile = basepath + "/etc/test.starterrordaemonize.conf"
+	ioutil.WriteFile(process.ConfigFile, []byte("[global]\nhello world\n"), 0644)
+	start := time.Now()
+	stdout, stderr, err := process.Start()
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		process.Stop()
+		process.Wait()
+		return
+	}
+	if elapsed := time.Since(start); elapsed >= process.StartTimeout {
+		t.Errorf("expected failed launcher to be detected, took %s", elapsed)
+	}
+	if stdout != nil || stderr != nil {
+		t.Errorf("expected no pipes on error, got %#v and %#v", stdout, stderr)
+	}
+	var startErr *gophpfpm.StartError
+	if !errors.As(err, &startErr) {
+		t.Errorf("expected StartError, got %#v", err)
+	} else if want, have := gophpfpm.StartPhaseDaemonize, startErr.Phase; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if process.ExitCode() <= 0 {
+		t.Errorf("expected the exit code of the launcher, got %d", process.ExitCode())
+	}
+}
+
+func TestProcess_StderrTail(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	if have := process.StderrTail(0); have != nil {
+		t.Errorf("expected nil, got %#v", have)
+	}
+
+	process.SetDatadirNamed(basepath+"/var", "test.stderrtail")
+	process.ErrorLog = "/proc/self/fd/2"
+	process.User = username
+	process.CaptureStderr = true
+	if err := process.SaveConfig(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	_, stderr, err := process.Start()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if stderr != nil {
+		t.Errorf("expected nil stderr, got %#v", stderr)
+	}
+	process.Stop()
+	process.Wait()
+
+	lines := process.StderrTail(0)
+	if len(lines) < 2 {
+		t.Errorf("expected lines of stderr, got %#v", lines)
+		return
+	}
+	if want, have := "fpm is running", strings.Join(lines, "\n"); !strings.Contains(have, want) {
+		t.Errorf("expected %#v in %#v", want, have)
+	}
+	if want, have := lines[len(lines)-1:], process.StderrTail(1); !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// kept after a failed start
+	process.ConfigFile = basepath + "/etc/test.stderrtail.conf"
+	ioutil.WriteFile(process.ConfigFile, []byte("[global]\nhello world\n"), 0644)
+	if _, _, err := process.Start(); err == nil {
+		t.Errorf("expected error, got nil")
+		process.Stop()
+		process.Wait()
+		return
+	}
+	if want, have := "ERROR", strings.Join(process.StderrTail(0), "\n"); !strings.Contains(have, want) {
+		t.Errorf("expected %#v in %#v", want, have)
+	}
+}
+
+func TestProcess_StartContext(t *testing.T) {
+	path := pathToPhpFpm
+	process := gophpfpm.NewProcess(path)
+	process.SetDatadir(basepath + "/var")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.startcontext.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err := process.StartContext(ctx)
+	if want, have := context.Canceled, err; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_StartContextDaemonize(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.startcontextdaemonize")
+	process.User = username
+	process.Daemonize = true
+	process.StdoutTo = ioutil.Discard
+
+	// never logs to stderr, as ErrorLog is a file
+	process.ReadinessMode = gophpfpm.ReadinessLog
+	if err := process.SaveConfig(basepath + "/etc/test.startcontextdaemonize.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	// cancel once daemonized
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		waitFor(time.Second*5, func() bool {
+			_, err := process.ReadPidFile()
+			return err == nil
+		})
+		cancel()
+	}()
+
+	started := make(chan error, 1)
+	go func() {
+		_, _, err := process.StartContext(ctx)
+		started <- err
+	}()
+	select {
+	case err := <-started:
+		if want, have := context.Canceled, err; want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+	case <-time.After(time.Second * 10):
+		t.Errorf("timed out waiting for StartContext to return")
+		return
+	}
+	if process.IsRunning() {
+		t.Errorf("expected the daemon to be killed")
+	}
+	if _, err := os.Stat(process.PidFile); !os.IsNotExist(err) {
+		t.Errorf("expected pid file to be removed, got %#v", err)
+	}
+}
+
+func TestProcess_Reload(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	if want, have := gophpfpm.ErrNotStarted, process.Reload(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	process.SetDatadir(basepath + "/var")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.reload.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if err := process.Reload(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := process.Stop(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestProcess_ReopenLogs(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	if want, have := gophpfpm.ErrNotStarted, process.ReopenLogs(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	process.SetDatadirNamed(basepath+"/var", "test.reopenlogs")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.reopenlogs.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	// rotate the error log
+	if err := os.Rename(process.ErrorLog, process.ErrorLog+".1"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	defer os.Remove(process.ErrorLog + ".1")
+	if err := process.ReopenLogs(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if !waitFor(time.Second*5, func() bool {
+		_, err := os.Stat(process.ErrorLog)
+		return err == nil
+	}) {
+		t.Errorf("expected error log to be reopened")
+	}
+	if !process.IsRunning() {
+		t.Errorf("expected process to keep running")
+	}
+	if err := process.Stop(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestProcess_StartExecFromPath(t *testing.T) {
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", path.Dir(pathToPhpFpm)+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	process := gophpfpm.NewProcess("not-exists-php-fpm")
+	process.SetDatadirNamed(basepath+"/var", "test.execpath")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.execpath.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err == nil {
+		t.Errorf("expected error, got nil")
+		process.Stop()
+		process.Wait()
+	} else if !strings.Contains(err.Error(), "not-exists-php-fpm") {
+		t.Errorf("expected error to mention the executable, got %#v", err.Error())
+	}
+
+	process.Exec = path.Base(pathToPhpFpm)
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if err := process.Stop(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestProcess_StartTempConfig(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.tempconfig")
+	process.ConfigFile = ""
+	process.User = username
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	configFile := process.ConfigFile
+	if configFile == "" {
+		t.Errorf("expected ConfigFile to be set")
+	} else if _, err := os.Stat(configFile); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	process.Stop()
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if _, err := os.Stat(configFile); !os.IsNotExist(err) {
+		t.Errorf("expected temporary config file to be removed, got %#v", err)
+	}
+	if want, have := "", process.ConfigFile; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// a new temporary config file on restart
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	process.Stop()
+	process.Wait()
+
+	// invalid config is not saved
+	process.PidFile = ""
+	process.ConfigFile = ""
+	var startErr *gophpfpm.StartError
+	if _, _, err := process.Start(); !errors.As(err, &startErr) {
+		t.Errorf("expected StartError, got %#v", err)
+	} else if want, have := gophpfpm.StartPhaseConfig, startErr.Phase; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_ConfigFreePort(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.Listen = "127.0.0.1:0"
+	process.Pools = []gophpfpm.Pool{
+		gophpfpm.NewPool("other", "0"),
+	}
+
+	f, err := process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	network, address := process.ListenAddr()
+	if want, have := "tcp", network; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := address, f.Section("www").Key("listen").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if address == "127.0.0.1:0" || !strings.HasPrefix(address, "127.0.0.1:") {
+		t.Errorf("expected a free port on 127.0.0.1, got %#v", address)
+	}
+	if want, have := "127.0.0.1:0", process.Listen; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if port := f.Section("other").Key("listen").String(); port == "0" || port == "" {
+		t.Errorf("expected a free port, got %#v", port)
+	}
+
+	// the port is kept for the same Listen
+	f, err = process.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := address, f.Section("www").Key("listen").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_StartFreePort(t *testing.T) {
+	var processes []*gophpfpm.Process
+	for _, name := range []string{"test.freeport1", "test.freeport2"} {
+		process := gophpfpm.NewProcess(pathToPhpFpm)
+		process.SetDatadirNamed(basepath+"/var", name)
+		process.Listen = "127.0.0.1:0"
+		process.User = username
+		if err := process.SaveConfig(); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			continue
+		}
+		if _, _, err := process.Start(); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			continue
+		}
+		defer func() {
+			process.Stop()
+			process.Wait()
+		}()
+		processes = append(processes, process)
+	}
+	if len(processes) != 2 {
+		return
+	}
+
+	_, address1 := processes[0].ListenAddr()
+	_, address2 := processes[1].ListenAddr()
+	if address1 == address2 {
+		t.Errorf("expected different addresses, got %#v", address1)
+	}
+	for _, process := range processes {
+		network, address := process.ListenAddr()
+		conn, err := net.Dial(network, address)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			continue
+		}
+		conn.Close()
+	}
+}
+
+func TestProcess_TestConfig(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	if err := process.TestConfig(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	process.SetDatadirNamed(basepath+"/var", "test.testconfig")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.testconfig.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if err := process.TestConfig(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if process.IsRunning() {
+		t.Errorf("expected process not to be started")
+	}
+
+	configFile := basepath + "/etc/test.testconfig.invalid.conf"
+	ioutil.WriteFile(configFile, []byte("[global]\nhello world\n"), 0644)
+	process.ConfigFile = configFile
+	err := process.TestConfig()
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	} else if !strings.Contains(err.Error(), configFile) {
+		t.Errorf("expected error to mention %#v, got %#v", configFile, err.Error())
+	}
+}
+
+func TestProcess_Version(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	version, err := process.Version()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if !regexp.MustCompile("^\\d+\\.\\d+").MatchString(version) {
+		t.Errorf("unexpected version %#v", version)
+	}
+
+	process = gophpfpm.NewProcess(basepath + "/not-exists/php-fpm")
+	if _, err := process.Version(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func ExampleProcess() {
+
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+
+	// SetDatadir equals to running these 6 settings:
+	// process.ConfigFile = basepath + "/phpfpm.conf"
+	// process.PidFile    = basepath + "/phpfpm.pid"
+	// process.ErrorLog   = basepath + "/phpfpm.error_log"
+	// process.SlowLog    = basepath + "/phpfpm.slow_log"
+	// process.AccessLog  = basepath + "/phpfpm.access_log"
+	// process.Listen     = basepath + "/phpfpm.sock"
+	process.SetDatadir(basepath + "/var")
+	process.User = username
+
+	// save the config file to basepath + "/etc/php-fpm.conf"
+	if err := process.SaveConfig(basepath + "/etc/example.conf"); err != nil {
+		panic(err)
+	}
+	stdout, stderr, err := process.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	// php-fpm may block if its output is not consumed
+	go io.Copy(ioutil.Discard, stdout)
+	go io.Copy(ioutil.Discard, stderr)
 
 	go func() {
 		// do something that needs phpfpm
@@ -0,0 +1,41 @@
+//go:build !windows
+// +build !windows
+
+package gophpfpm_test
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/yookoala/gophpfpm"
+)
+
+func TestProcess_Signal(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	if want, have := gophpfpm.ErrNotStarted, process.Signal(syscall.SIGUSR1); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	process.SetDatadirNamed(basepath+"/var", "test.signal")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.signal.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if err := process.Signal(syscall.SIGUSR1); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if !process.IsRunning() {
+		t.Errorf("expected process to keep running")
+	}
+	if err := process.Signal(syscall.SIGQUIT); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := process.Wait(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
@@ -0,0 +1,137 @@
+package gophpfpm
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// ProxyListener listens on the given network and address
+// and forwards the accepted connections to the pool (see
+// Proxy). Useful to expose a pool listening on a unix socket
+// to remote FastCGI clients.
+//
+// To serve TLS or authenticate the clients, wrap the listener
+// (e.g. with tls.NewListener) and use Proxy instead.
+func (proc *Process) ProxyListener(network, addr string) (io.Closer, error) {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return proc.Proxy(l), nil
+}
+
+// Proxy forwards the connections accepted by the listener to
+// the Listen address of the pool, until the returned Closer
+// is closed. Closing it closes the listener and the forwarded
+// connections.
+func (proc *Process) Proxy(l net.Listener) io.Closer {
+	network, address := proc.Address()
+	p := &proxy{
+		listener: l,
+		network:  network,
+		address:  address,
+		conns:    make(map[net.Conn]bool),
+	}
+	p.wg.Add(1)
+	go p.serve()
+	return p
+}
+
+// proxy forwards the connections of a listener to a pool
+type proxy struct {
+	listener net.Listener
+
+	// network and address of the pool
+	network, address string
+
+	// wg waits for the accept loop and the forwarding
+	wg sync.WaitGroup
+
+	// mu guards the connections below
+	mu     sync.Mutex
+	conns  map[net.Conn]bool
+	closed bool
+}
+
+// serve accepts the connections until the listener is closed
+func (p *proxy) serve() {
+	defer p.wg.Done()
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		p.wg.Add(1)
+		go p.forward(conn)
+	}
+}
+
+// forward connects the accepted connection to the pool and
+// copies the data in both directions until either side is
+// closed
+func (p *proxy) forward(conn net.Conn) {
+	defer p.wg.Done()
+	upstream, err := net.Dial(p.network, p.address)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	if !p.track(conn, upstream) {
+		conn.Close()
+		upstream.Close()
+		return
+	}
+	defer p.untrack(conn, upstream)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+	conn.Close()
+	upstream.Close()
+	<-done
+}
+
+// track adds the connections to be closed along with the
+// proxy. Returns false if the proxy is already closed.
+func (p *proxy) track(conns ...net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	for _, conn := range conns {
+		p.conns[conn] = true
+	}
+	return true
+}
+
+// untrack removes the connections added by track
+func (p *proxy) untrack(conns ...net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range conns {
+		delete(p.conns, conn)
+	}
+}
+
+// Close closes the listener and the forwarded connections,
+// then waits for the forwarding to finish
+func (p *proxy) Close() error {
+	err := p.listener.Close()
+	p.mu.Lock()
+	p.closed = true
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+	return err
+}
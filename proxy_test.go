@@ -0,0 +1,74 @@
+package gophpfpm_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/yookoala/gophpfpm"
+)
+
+func TestProcess_Proxy(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.proxy")
+	process.User = username
+	process.PingPath = "/ping"
+	if err := process.SaveConfig(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	defer func() {
+		process.Stop()
+		process.Wait()
+	}()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	proxy := process.Proxy(l)
+
+	client := &gophpfpm.FcgiClient{
+		Network: "tcp",
+		Address: l.Addr().String(),
+	}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Do(gophpfpm.FcgiRequest{ScriptFilename: "/ping"})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			continue
+		}
+		if want, have := "pong", strings.TrimSpace(string(resp.Body)); want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+	}
+
+	if err := proxy.Close(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if _, err := client.Do(gophpfpm.FcgiRequest{ScriptFilename: "/ping"}); err == nil {
+		t.Errorf("expected error after Close, got nil")
+	}
+}
+
+func TestProcess_ProxyListener(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.Listen = "127.0.0.1:9000"
+	if _, err := process.ProxyListener("tcp", "invalid address"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	proxy, err := process.ProxyListener("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if err := proxy.Close(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package gophpfpm
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// signals handled by php-fpm
+var (
+	sigGracefulStop os.Signal = syscall.SIGQUIT
+	sigReload       os.Signal = syscall.SIGUSR2
+	sigReopenLogs   os.Signal = syscall.SIGUSR1
+)
+
+// signalProcess sends the signal to the process
+func signalProcess(p *os.Process, sig os.Signal) error {
+	return p.Signal(sig)
+}
+
+// isAlive checks if the process still exists
+func isAlive(p *os.Process) bool {
+	err := p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
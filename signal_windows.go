@@ -0,0 +1,50 @@
+//go:build windows
+// +build windows
+
+package gophpfpm
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// signals handled by php-fpm. SIGUSR1 and SIGUSR2 are
+// not defined on windows, so the linux values are used
+var (
+	sigGracefulStop os.Signal = syscall.SIGQUIT
+	sigReload       os.Signal = syscall.Signal(0xc)
+	sigReopenLogs   os.Signal = syscall.Signal(0xa)
+)
+
+// signalProcess sends the signal to the process. Only
+// os.Kill can be delivered on windows, so the process is
+// killed for the signals that stop php-fpm
+func signalProcess(p *os.Process, sig os.Signal) error {
+	switch sig {
+	case os.Kill, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT:
+		return p.Kill()
+	}
+	return fmt.Errorf("signal %s is not supported on windows", sig)
+}
+
+// isAlive checks if the process is still running. An exited
+// process can still be opened while a handle to it is open
+// (e.g. the one of p, which also keeps its pid from being
+// reused), so its exit code is checked instead
+func isAlive(p *os.Process) bool {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(p.Pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
+
+// stillActive is the exit code of a running process
+// (STILL_ACTIVE), which syscall does not define
+const stillActive = 259
@@ -0,0 +1,61 @@
+package gophpfpm
+
+// Spec is a declarative description of a process, to be
+// decoded from a JSON or YAML config file. Zero values are
+// left to the defaults of NewProcess and of the generated
+// config.
+type Spec struct {
+	// path to the php-fpm executable. See Process.Exec
+	Exec string `json:"exec" yaml:"exec"`
+
+	// datadir of the process files. See Process.SetDatadir
+	Datadir string `json:"datadir,omitempty" yaml:"datadir,omitempty"`
+
+	// listen address of the pool. Overrides the socket in
+	// Datadir if both are set. See Pool.Listen
+	Listen string `json:"listen,omitempty" yaml:"listen,omitempty"`
+
+	// name of the pool section. See Pool.PoolName
+	PoolName string `json:"pool_name,omitempty" yaml:"pool_name,omitempty"`
+
+	// user and group of the FastCGI process.
+	// See Pool.User and Pool.Group
+	User  string `json:"user,omitempty" yaml:"user,omitempty"`
+	Group string `json:"group,omitempty" yaml:"group,omitempty"`
+
+	// process manager settings (pm, pm.max_children,
+	// pm.start_servers, pm.min_spare_servers and
+	// pm.max_spare_servers). See Pool.
+	ProcessManager  string `json:"pm,omitempty" yaml:"pm,omitempty"`
+	MaxChildren     int    `json:"max_children,omitempty" yaml:"max_children,omitempty"`
+	StartServers    int    `json:"start_servers,omitempty" yaml:"start_servers,omitempty"`
+	MinSpareServers int    `json:"min_spare_servers,omitempty" yaml:"min_spare_servers,omitempty"`
+	MaxSpareServers int    `json:"max_spare_servers,omitempty" yaml:"max_spare_servers,omitempty"`
+}
+
+// NewProcessFromSpec creates a new process descriptor
+// from the spec. Returns error if the datadir is invalid
+// or the process does not pass Validate.
+func NewProcessFromSpec(spec Spec) (*Process, error) {
+	proc := NewProcess(spec.Exec)
+	if spec.Datadir != "" {
+		if err := proc.SetDatadir(spec.Datadir); err != nil {
+			return nil, err
+		}
+	}
+	if spec.Listen != "" {
+		proc.Listen = spec.Listen
+	}
+	proc.PoolName = spec.PoolName
+	proc.User = spec.User
+	proc.Group = spec.Group
+	proc.ProcessManager = spec.ProcessManager
+	proc.MaxChildren = spec.MaxChildren
+	proc.StartServers = spec.StartServers
+	proc.MinSpareServers = spec.MinSpareServers
+	proc.MaxSpareServers = spec.MaxSpareServers
+	if err := proc.Validate(); err != nil {
+		return nil, err
+	}
+	return proc, nil
+}
@@ -0,0 +1,92 @@
+package gophpfpm_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/yookoala/gophpfpm"
+)
+
+func TestNewProcessFromSpec(t *testing.T) {
+	var spec gophpfpm.Spec
+	if err := json.Unmarshal([]byte(`{
+		"exec": "/usr/sbin/php-fpm",
+		"datadir": "`+basepath+`/var",
+		"listen": "127.0.0.1:9000",
+		"pool_name": "tenant",
+		"user": "nobody",
+		"group": "nogroup",
+		"pm": "dynamic",
+		"max_children": 20,
+		"start_servers": 4,
+		"min_spare_servers": 2,
+		"max_spare_servers": 6
+	}`), &spec); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	process, err := gophpfpm.NewProcessFromSpec(spec)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if want, have := "/usr/sbin/php-fpm", process.Exec; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := basepath+"/var/phpfpm.pid", process.PidFile; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "127.0.0.1:9000", process.Listen; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "tenant", process.PoolName; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "nobody", process.User; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "nogroup", process.Group; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := gophpfpm.PMDynamic, process.ProcessManager; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 20, process.MaxChildren; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 4, process.StartServers; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 2, process.MinSpareServers; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 6, process.MaxSpareServers; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestNewProcessFromSpecInvalid(t *testing.T) {
+	tests := []struct {
+		desc string
+		spec gophpfpm.Spec
+	}{
+		{"invalid datadir", gophpfpm.Spec{
+			Exec:    pathToPhpFpm,
+			Datadir: basepath + "/not-exists",
+		}},
+		{"no datadir", gophpfpm.Spec{
+			Exec:   pathToPhpFpm,
+			Listen: "127.0.0.1:9000",
+		}},
+		{"invalid spare servers", gophpfpm.Spec{
+			Exec:            pathToPhpFpm,
+			Datadir:         basepath + "/var",
+			MinSpareServers: 4,
+			MaxSpareServers: 2,
+		}},
+	}
+	for _, test := range tests {
+		if _, err := gophpfpm.NewProcessFromSpec(test.spec); err == nil {
+			t.Errorf("%s: expected error, got nil", test.desc)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package gophpfpm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PoolStatus is the status of a php-fpm pool as
+// reported by its status page (pm.status_path)
+type PoolStatus struct {
+
+	// name of the pool
+	Pool string `json:"pool"`
+
+	// process manager of the pool (static, dynamic or ondemand)
+	ProcessManager string `json:"process_manager"`
+
+	// time when the pool was started
+	StartTime time.Time `json:"start_time"`
+
+	// number of seconds since the pool was started
+	StartSince int `json:"start_since"`
+
+	// number of requests accepted by the pool
+	AcceptedConn int `json:"accepted_conn"`
+
+	// number of requests in the queue of pending connections
+	ListenQueue int `json:"listen_queue"`
+
+	// maximum number of requests in the queue of pending
+	// connections since the pool was started
+	MaxListenQueue int `json:"max_listen_queue"`
+
+	// size of the socket queue of pending connections
+	ListenQueueLen int `json:"listen_queue_len"`
+
+	// number of idle processes
+	IdleProcesses int `json:"idle_processes"`
+
+	// number of active processes
+	ActiveProcesses int `json:"active_processes"`
+
+	// number of idle and active processes
+	TotalProcesses int `json:"total_processes"`
+
+	// maximum number of active processes since
+	// the pool was started
+	MaxActiveProcesses int `json:"max_active_processes"`
+
+	// number of times the process limit (pm.max_children)
+	// has been reached
+	MaxChildrenReached int `json:"max_children_reached"`
+
+	// number of requests that exceeded
+	// request_slowlog_timeout
+	SlowRequests int `json:"slow_requests"`
+
+	// status of each worker process. Only reported
+	// by the full status page (see FullStatus)
+	Processes []ProcessStatus `json:"processes,omitempty"`
+}
+
+// ProcessStatus is the status of a worker process as
+// reported by the full status page
+type ProcessStatus struct {
+
+	// pid of the process
+	Pid int `json:"pid"`
+
+	// state of the process (e.g. Idle, Running)
+	State string `json:"state"`
+
+	// time when the process was started
+	StartTime time.Time `json:"start_time"`
+
+	// number of seconds since the process was started
+	StartSince int `json:"start_since"`
+
+	// number of requests served by the process
+	Requests int `json:"requests"`
+
+	// duration of the last request in microseconds
+	RequestDuration int `json:"request_duration"`
+
+	// method, URI and content length of the last request
+	RequestMethod string `json:"request_method"`
+	RequestURI    string `json:"request_uri"`
+	ContentLength int    `json:"content_length"`
+
+	// user of the last request (PHP_AUTH_USER)
+	User string `json:"user"`
+
+	// script of the last request
+	Script string `json:"script"`
+
+	// %cpu and memory used by the last request
+	LastRequestCPU    float64 `json:"last_request_cpu"`
+	LastRequestMemory int     `json:"last_request_memory"`
+}
+
+// Metrics returns the numeric values of the status keyed by
+// metric name, which is the json name of the field. Counters
+// (accepted_conn, max_children_reached, slow_requests) only
+// increase since the pool was started. The others are gauges.
+func (status PoolStatus) Metrics() map[string]float64 {
+	return map[string]float64{
+		"start_since":          float64(status.StartSince),
+		"accepted_conn":        float64(status.AcceptedConn),
+		"listen_queue":         float64(status.ListenQueue),
+		"max_listen_queue":     float64(status.MaxListenQueue),
+		"listen_queue_len":     float64(status.ListenQueueLen),
+		"idle_processes":       float64(status.IdleProcesses),
+		"active_processes":     float64(status.ActiveProcesses),
+		"total_processes":      float64(status.TotalProcesses),
+		"max_active_processes": float64(status.MaxActiveProcesses),
+		"max_children_reached": float64(status.MaxChildrenReached),
+		"slow_requests":        float64(status.SlowRequests),
+	}
+}
+
+// statusTimeLayout is the time format of the status page
+const statusTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// Status fetches the status page of the pool through
+// FastCGI. StatusPath must be set. The Pool of the status
+// is PoolName if the status page does not report it.
+func (proc *Process) Status() (status PoolStatus, err error) {
+	return proc.fetchStatus(proc.StatusPath)
+}
+
+// StatusMetrics fetches the status page of the pool like
+// Status does and returns its numeric values keyed by metric
+// name (e.g. "active_processes"). See PoolStatus.Metrics.
+func (proc *Process) StatusMetrics() (map[string]float64, error) {
+	status, err := proc.Status()
+	if err != nil {
+		return nil, err
+	}
+	return status.Metrics(), nil
+}
+
+// FullStatus fetches the full status page of the pool
+// through FastCGI, which also reports the status of
+// each worker process. StatusPath must be set.
+func (proc *Process) FullStatus() (status PoolStatus, err error) {
+	return proc.fetchStatus(proc.StatusPath + "?full")
+}
+
+// fetchStatus fetches and parses the status page
+// of the pool at the path
+func (proc *Process) fetchStatus(path string) (status PoolStatus, err error) {
+	if proc.StatusPath == "" {
+		return status, proc.poolError(fmt.Errorf("status path is not set"))
+	}
+	body, err := proc.fcgiGet(path)
+	if err != nil {
+		return status, proc.poolError(err)
+	}
+	if status, err = ParsePoolStatus(body); err != nil {
+		return status, proc.poolError(err)
+	}
+	if status.Pool == "" {
+		status.Pool = proc.name()
+	}
+	return
+}
+
+// poolError prefixes the error with the pool name
+func (proc *Process) poolError(err error) error {
+	return fmt.Errorf("pool %#v: %w", proc.name(), err)
+}
+
+// Ping requests the ping page of the pool through FastCGI
+// and checks the response against PingResponse (or "pong"
+// if not set). PingPath must be set.
+func (proc *Process) Ping() error {
+	if proc.PingPath == "" {
+		return proc.poolError(fmt.Errorf("ping path is not set"))
+	}
+	body, err := proc.fcgiGet(proc.PingPath)
+	if err != nil {
+		return proc.poolError(err)
+	}
+
+	expected := proc.PingResponse
+	if expected == "" {
+		expected = "pong"
+	}
+	if response := string(bytes.TrimSpace(body)); response != expected {
+		return proc.poolError(fmt.Errorf("unexpected ping response %#v, expected %#v",
+			response, expected))
+	}
+	return nil
+}
+
+// MonitorHealth checks the health of the pool every interval
+// until the context is done. The pool is healthy if Ping
+// succeeds or, if PingPath is not set, the Listen address is
+// connectable.
+//
+// fn is called with the result of the first check, then only
+// when the pool turns healthy or unhealthy, with the error of
+// the failed check (nil if healthy). fn is called from the
+// checking loop, so the next check waits for it to return;
+// hand slow work off to another goroutine.
+//
+// The interval defaults to 10 seconds if not positive.
+// MonitorHealth blocks until the context is done and
+// returns its error.
+func (proc *Process) MonitorHealth(ctx context.Context, interval time.Duration, fn func(healthy bool, err error)) error {
+	if interval <= 0 {
+		interval = time.Second * 10
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	checked, healthy := false, false
+	for {
+		err := proc.checkHealth()
+		if !checked || healthy != (err == nil) {
+			checked, healthy = true, err == nil
+			fn(healthy, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkHealth pings the pool, or dials the Listen
+// address if PingPath is not set
+func (proc *Process) checkHealth() error {
+	if proc.PingPath != "" {
+		return proc.Ping()
+	}
+	network, address := proc.Address()
+	connectTimeout := durationOrDefault(proc.FcgiConnectTimeout, defaultFcgiTimeout)
+	conn, err := net.DialTimeout(network, address, connectTimeout)
+	if err != nil {
+		return proc.poolError(fcgiTimeoutError("connect", connectTimeout, err))
+	}
+	return conn.Close()
+}
+
+// ParsePoolStatus parses the plain text output of php-fpm
+// status page, in either the default or the full format
+func ParsePoolStatus(body []byte) (status PoolStatus, err error) {
+	var worker *ProcessStatus
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "***") {
+			// separator of the worker processes
+			status.Processes = append(status.Processes, ProcessStatus{})
+			worker = &status.Processes[len(status.Processes)-1]
+			continue
+		}
+		i := strings.Index(scanner.Text(), ":")
+		if i < 0 {
+			continue
+		}
+		name := strings.TrimSpace(scanner.Text()[:i])
+		value := strings.TrimSpace(scanner.Text()[i+1:])
+
+		var target *int
+		var timeTarget *time.Time
+		if worker != nil {
+			switch name {
+			case "pid":
+				target = &worker.Pid
+			case "state":
+				worker.State = value
+			case "start time":
+				timeTarget = &worker.StartTime
+			case "start since":
+				target = &worker.StartSince
+			case "requests":
+				target = &worker.Requests
+			case "request duration":
+				target = &worker.RequestDuration
+			case "request method":
+				worker.RequestMethod = value
+			case "request URI":
+				worker.RequestURI = value
+			case "content length":
+				target = &worker.ContentLength
+			case "user":
+				worker.User = value
+			case "script":
+				worker.Script = value
+			case "last request cpu":
+				if worker.LastRequestCPU, err = strconv.ParseFloat(value, 64); err != nil {
+					return status, fmt.Errorf("malformed status %s: %#v", name, value)
+				}
+			case "last request memory":
+				target = &worker.LastRequestMemory
+			}
+		} else {
+			switch name {
+			case "pool":
+				status.Pool = value
+			case "process manager":
+				status.ProcessManager = value
+			case "start time":
+				timeTarget = &status.StartTime
+			case "start since":
+				target = &status.StartSince
+			case "accepted conn":
+				target = &status.AcceptedConn
+			case "listen queue":
+				target = &status.ListenQueue
+			case "max listen queue":
+				target = &status.MaxListenQueue
+			case "listen queue len":
+				target = &status.ListenQueueLen
+			case "idle processes":
+				target = &status.IdleProcesses
+			case "active processes":
+				target = &status.ActiveProcesses
+			case "total processes":
+				target = &status.TotalProcesses
+			case "max active processes":
+				target = &status.MaxActiveProcesses
+			case "max children reached":
+				target = &status.MaxChildrenReached
+			case "slow requests":
+				target = &status.SlowRequests
+			}
+		}
+		if target != nil {
+			if *target, err = strconv.Atoi(value); err != nil {
+				return status, fmt.Errorf("malformed status %s: %#v", name, value)
+			}
+		}
+		if timeTarget != nil {
+			if *timeTarget, err = time.Parse(statusTimeLayout, value); err != nil {
+				return status, fmt.Errorf("malformed status %s: %#v", name, value)
+			}
+		}
+	}
+	err = scanner.Err()
+	return
+}
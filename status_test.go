@@ -0,0 +1,443 @@
+package gophpfpm_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gophpfpm"
+)
+
+const statusText = `pool:                 www
+process manager:      dynamic
+start time:           14/Oct/2026:10:00:00 +0000
+start since:          120
+accepted conn:        12
+listen queue:         0
+max listen queue:     0
+listen queue len:     128
+idle processes:       1
+active processes:     1
+total processes:      2
+max active processes: 2
+max children reached: 0
+slow requests:        0
+`
+
+// serveFcgi serves the handler over FastCGI with a
+// tcp listener and returns the listener
+func serveFcgi(t *testing.T, handler http.Handler) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	go fcgi.Serve(l, handler)
+	return l
+}
+
+func TestProcess_Status(t *testing.T) {
+	l := serveFcgi(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "File not found.")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, statusText)
+	}))
+	defer l.Close()
+
+	process := &gophpfpm.Process{}
+	process.Listen = l.Addr().String()
+
+	if _, err := process.Status(); err == nil {
+		t.Errorf("expected error for empty status path, got nil")
+	}
+
+	process.StatusPath = "/status"
+	status, err := process.Status()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := time.Date(2026, time.October, 14, 10, 0, 0, 0, time.UTC), status.StartTime; !want.Equal(have) {
+		t.Errorf("expected %s, got %s", want, have)
+	}
+	status.StartTime = time.Time{}
+	if want, have := (gophpfpm.PoolStatus{
+		Pool:               "www",
+		ProcessManager:     "dynamic",
+		StartSince:         120,
+		AcceptedConn:       12,
+		ListenQueue:        0,
+		ListenQueueLen:     128,
+		IdleProcesses:      1,
+		ActiveProcesses:    1,
+		TotalProcesses:     2,
+		MaxActiveProcesses: 2,
+	}), status; !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	process.StatusPath = "/not-status"
+	if _, err := process.Status(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+const fullStatusText = statusText + `
+************************
+pid:                  1234
+state:                Idle
+start time:           14/Oct/2026:10:00:00 +0000
+start since:          120
+requests:             6
+request duration:     1500
+request method:       GET
+request URI:          /index.php?foo=bar
+content length:       0
+user:                 -
+script:               /var/www/index.php
+last request cpu:     0.67
+last request memory:  2097152
+
+************************
+pid:                  1235
+state:                Running
+start time:           14/Oct/2026:10:00:01 +0000
+start since:          119
+requests:             6
+request duration:     300
+request method:       POST
+request URI:          /status?full
+content length:       12
+user:                 -
+script:               -
+last request cpu:     0.00
+last request memory:  0
+`
+
+func TestParsePoolStatus(t *testing.T) {
+	status, err := gophpfpm.ParsePoolStatus([]byte(fullStatusText))
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := 2, status.TotalProcesses; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 2, len(status.Processes); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+		return
+	}
+	worker := status.Processes[0]
+	if want, have := time.Date(2026, time.October, 14, 10, 0, 0, 0, time.UTC), worker.StartTime; !want.Equal(have) {
+		t.Errorf("expected %s, got %s", want, have)
+	}
+	worker.StartTime = time.Time{}
+	if want, have := (gophpfpm.ProcessStatus{
+		Pid:               1234,
+		State:             "Idle",
+		StartSince:        120,
+		Requests:          6,
+		RequestDuration:   1500,
+		RequestMethod:     "GET",
+		RequestURI:        "/index.php?foo=bar",
+		User:              "-",
+		Script:            "/var/www/index.php",
+		LastRequestCPU:    0.67,
+		LastRequestMemory: 2097152,
+	}), worker; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "Running", status.Processes[1].State; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 12, status.Processes[1].ContentLength; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// time of the pool is not overridden by the processes
+	if want, have := 120, status.StartSince; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	b, err := json.Marshal(status)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	for _, key := range []string{`"accepted_conn":12`, `"max_active_processes":2`, `"request_uri":"/index.php?foo=bar"`} {
+		if !strings.Contains(string(b), key) {
+			t.Errorf("expected %s in %s", key, b)
+		}
+	}
+
+	if _, err := gophpfpm.ParsePoolStatus([]byte("accepted conn: many\n")); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if _, err := gophpfpm.ParsePoolStatus([]byte("start time: yesterday\n")); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestProcess_StatusMetrics(t *testing.T) {
+	l := serveFcgi(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, statusText)
+	}))
+	defer l.Close()
+
+	process := &gophpfpm.Process{}
+	process.Listen = l.Addr().String()
+	if _, err := process.StatusMetrics(); err == nil {
+		t.Errorf("expected error for empty status path, got nil")
+	}
+
+	process.StatusPath = "/status"
+	metrics, err := process.StatusMetrics()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := (map[string]float64{
+		"start_since":          120,
+		"accepted_conn":        12,
+		"listen_queue":         0,
+		"max_listen_queue":     0,
+		"listen_queue_len":     128,
+		"idle_processes":       1,
+		"active_processes":     1,
+		"total_processes":      2,
+		"max_active_processes": 2,
+		"max_children_reached": 0,
+		"slow_requests":        0,
+	}), metrics; !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_FullStatus(t *testing.T) {
+	l := serveFcgi(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "File not found.")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		if _, full := r.URL.Query()["full"]; full {
+			fmt.Fprint(w, fullStatusText)
+		} else {
+			fmt.Fprint(w, statusText)
+		}
+	}))
+	defer l.Close()
+
+	process := &gophpfpm.Process{}
+	process.Listen = l.Addr().String()
+	if _, err := process.FullStatus(); err == nil {
+		t.Errorf("expected error for empty status path, got nil")
+	}
+
+	process.StatusPath = "/status"
+	status, err := process.FullStatus()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := 2, len(status.Processes); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if status, err = process.Status(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := 0, len(status.Processes); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_Ping(t *testing.T) {
+	l := serveFcgi(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ping":
+			fmt.Fprint(w, "pong")
+		case "/ping-custom":
+			fmt.Fprint(w, "hello")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "File not found.")
+		}
+	}))
+	defer l.Close()
+
+	process := &gophpfpm.Process{}
+	process.Listen = l.Addr().String()
+	if err := process.Ping(); err == nil {
+		t.Errorf("expected error for empty ping path, got nil")
+	}
+
+	process.PingPath = "/ping"
+	if err := process.Ping(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	process.PingPath = "/ping-custom"
+	if err := process.Ping(); err == nil {
+		t.Errorf("expected error for unexpected response, got nil")
+	}
+	process.PingResponse = "hello"
+	if err := process.Ping(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	process.PingPath = "/not-ping"
+	if err := process.Ping(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	l.Close()
+	process.PingPath = "/ping"
+	if err := process.Ping(); err == nil {
+		t.Errorf("expected error for closed listener, got nil")
+	}
+}
+
+func TestProcess_StatusPoolName(t *testing.T) {
+	l := serveFcgi(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/status":
+			fmt.Fprint(w, strings.Replace(statusText, "pool:                 www\n", "", 1))
+		default:
+			fmt.Fprint(w, "hello")
+		}
+	}))
+	defer l.Close()
+
+	process := &gophpfpm.Process{}
+	process.Listen = l.Addr().String()
+	process.PoolName = "tenant"
+	process.StatusPath = "/status"
+	process.PingPath = "/ping"
+
+	status, err := process.Status()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "tenant", status.Pool; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	err = process.Ping()
+	if err == nil {
+		t.Errorf("expected error for unexpected response, got nil")
+		return
+	}
+	if want, have := `pool "tenant": `, err.Error(); !strings.HasPrefix(have, want) {
+		t.Errorf("expected prefix %#v, got %#v", want, have)
+	}
+}
+
+func TestProcess_StatusPhpFpm(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadir(basepath + "/var")
+	process.User = username
+	process.StatusPath = "/status"
+	process.PingPath = "/ping"
+	if err := process.SaveConfig(basepath + "/etc/test.status.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	defer process.Wait()
+	defer process.Stop()
+
+	status, err := process.Status()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want, have := "www", status.Pool; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "dynamic", status.ProcessManager; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if err := process.Ping(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestProcess_MonitorHealth(t *testing.T) {
+	for _, pingPath := range []string{"", "/ping"} {
+		l := serveFcgi(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "pong")
+		}))
+
+		process := &gophpfpm.Process{}
+		process.Listen = l.Addr().String()
+		process.PingPath = pingPath
+
+		type event struct {
+			healthy bool
+			err     error
+		}
+		events := make(chan event, 10)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- process.MonitorHealth(ctx, 5*time.Millisecond, func(healthy bool, err error) {
+				events <- event{healthy, err}
+			})
+		}()
+
+		if e := <-events; !e.healthy || e.err != nil {
+			t.Errorf("%#v: expected healthy, got %#v", pingPath, e)
+		}
+		l.Close()
+		if e := <-events; e.healthy || e.err == nil {
+			t.Errorf("%#v: expected unhealthy, got %#v", pingPath, e)
+		}
+
+		// no event without a transition
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		if want, have := context.Canceled, <-done; want != have {
+			t.Errorf("%#v: expected %#v, got %#v", pingPath, want, have)
+		}
+		if want, have := 0, len(events); want != have {
+			t.Errorf("%#v: expected %#v, got %#v", pingPath, want, have)
+		}
+	}
+}
+
+func TestProcess_MonitorHealthInterval(t *testing.T) {
+	process := &gophpfpm.Process{}
+	process.Listen = "127.0.0.1:9000"
+	for _, interval := range []time.Duration{0, -time.Second} {
+		// checked once with the default interval
+		ctx, cancel := context.WithCancel(context.Background())
+		checks := 0
+		err := process.MonitorHealth(ctx, interval, func(healthy bool, err error) {
+			checks++
+			cancel()
+		})
+		if want, have := context.Canceled, err; want != have {
+			t.Errorf("%s: expected %#v, got %#v", interval, want, have)
+		}
+		if want, have := 1, checks; want != have {
+			t.Errorf("%s: expected %#v, got %#v", interval, want, have)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package gophpfpm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Supervise starts php-fpm and keeps it running until the
+// context is done or the process is stopped with Stop,
+// StopWithSignal or StopContext.
+//
+// If php-fpm exits for any other reason, it is restarted
+// after a backoff (see RestartBackoffInitial and
+// RestartBackoffMax). The backoff is reset once php-fpm
+// has stayed up for RestartBackoffMax. Supervise gives up
+// and returns error once MaxRestarts is reached. A php-fpm
+// that fails to get ready is killed before Supervise
+// retries or returns (see StartContext). Returns
+// ErrAlreadyStarted without touching the process if it
+// is already running.
+//
+// When the context is done, php-fpm is stopped gracefully
+// with SIGQUIT and killed if it is still running after
+// StopGracePeriod. The context's error is then returned.
+// Returns nil if php-fpm is stopped by the caller.
+//
+// The output of php-fpm is discarded unless StdoutTo or
+// StderrTo is set.
+func (proc *Process) Supervise(ctx context.Context) (err error) {
+	// there is no caller to consume the pipes
+	proc.mu.Lock()
+	proc.discardOutput = true
+	proc.mu.Unlock()
+	defer func() {
+		proc.mu.Lock()
+		proc.discardOutput = false
+		proc.mu.Unlock()
+	}()
+
+	initialBackoff := proc.RestartBackoffInitial
+	if initialBackoff == 0 {
+		initialBackoff = time.Second
+	}
+	maxBackoff := proc.RestartBackoffMax
+	if maxBackoff == 0 {
+		maxBackoff = time.Second * 30
+	}
+	grace := proc.StopGracePeriod
+	if grace == 0 {
+		grace = time.Second * 10
+	}
+	backoff := initialBackoff
+
+	if _, _, err = proc.StartContext(ctx); err != nil {
+		return
+	}
+	started := time.Now()
+	for restarts := 0; ; restarts++ {
+		waited := make(chan error, 1)
+		go func() {
+			waited <- proc.Wait()
+		}()
+
+		var exitErr error
+		select {
+		case <-ctx.Done():
+			stopCtx, cancel := context.WithTimeout(context.Background(), grace)
+			proc.StopContext(stopCtx)
+			cancel()
+			return ctx.Err()
+		case exitErr = <-waited:
+		}
+		proc.mu.Lock()
+		stopped := proc.stopped
+		proc.mu.Unlock()
+		if stopped {
+			return nil
+		}
+		if exitErr == nil {
+			exitErr = fmt.Errorf("php-fpm exited")
+		}
+		if time.Since(started) >= maxBackoff {
+			// it was healthy for a while
+			backoff = initialBackoff
+		}
+		if proc.MaxRestarts > 0 && restarts >= proc.MaxRestarts {
+			return fmt.Errorf("php-fpm is restarted %d times: %s", restarts, exitErr)
+		}
+
+		// restart until it starts or the context is done
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			proc.mu.Lock()
+			stopped := proc.stopped
+			proc.mu.Unlock()
+			if stopped {
+				// stopped by the caller during the backoff
+				return nil
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			if _, _, err = proc.StartContext(ctx); err == nil {
+				started = time.Now()
+				break
+			}
+			if errors.Is(err, ErrAlreadyStarted) {
+				// started by someone else, leave it alone
+				return
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if restarts++; proc.MaxRestarts > 0 && restarts >= proc.MaxRestarts {
+				return fmt.Errorf("php-fpm is restarted %d times: %s", restarts, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package gophpfpm_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gophpfpm"
+)
+
+// waitFor polls until the condition is met or timed out
+func waitFor(timeout time.Duration, condition func() bool) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); {
+		if condition() {
+			return true
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return false
+}
+
+// isListening checks if the process accepts connection
+func isListening(process *gophpfpm.Process) bool {
+	conn, err := net.Dial(process.Address())
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// killPid kills the process behind the back of gophpfpm,
+// as if it crashed
+func killPid(pid int) {
+	if p, err := os.FindProcess(pid); err == nil {
+		p.Kill()
+	}
+}
+
+func TestProcess_Supervise(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.supervise")
+	process.User = username
+	process.RestartBackoffInitial = time.Millisecond * 10
+	if err := process.SaveConfig(basepath + "/etc/test.supervise.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	supervised := make(chan error, 1)
+	go func() {
+		supervised <- process.Supervise(context.Background())
+	}()
+	if !waitFor(time.Second*5, func() bool {
+		return process.IsRunning() && isListening(process)
+	}) {
+		t.Errorf("expected process to be started")
+		return
+	}
+
+	// the output is discarded without changing the config
+	if process.StdoutTo != nil || process.StderrTo != nil {
+		t.Errorf("expected StdoutTo and StderrTo to be left nil")
+	}
+
+	// crash is restarted
+	pid := process.Pid()
+	killPid(pid)
+	if !waitFor(time.Second*5, func() bool {
+		return process.IsRunning() && process.Pid() != pid && isListening(process)
+	}) {
+		t.Errorf("expected process to be restarted")
+	}
+
+	// stop is not restarted
+	process.Stop()
+	select {
+	case err := <-supervised:
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+	case <-time.After(time.Second * 5):
+		t.Errorf("timed out waiting for Supervise to return")
+	}
+}
+
+func TestProcess_SuperviseContext(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.supervisecontext")
+	process.User = username
+	if err := process.SaveConfig(basepath + "/etc/test.supervisecontext.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	supervised := make(chan error, 1)
+	go func() {
+		supervised <- process.Supervise(ctx)
+	}()
+	if !waitFor(time.Second*5, process.IsRunning) {
+		t.Errorf("expected process to be started")
+	}
+
+	cancel()
+	select {
+	case err := <-supervised:
+		if want, have := context.Canceled, err; want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+	case <-time.After(time.Second * 5):
+		t.Errorf("timed out waiting for Supervise to return")
+	}
+	if process.IsRunning() {
+		t.Errorf("expected process to be stopped")
+	}
+}
+
+func TestProcess_SuperviseStopDuringBackoff(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.supervisebackoff")
+	process.User = username
+	process.RestartBackoffInitial = time.Millisecond * 500
+	if err := process.SaveConfig(basepath + "/etc/test.supervisebackoff.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	supervised := make(chan error, 1)
+	go func() {
+		supervised <- process.Supervise(context.Background())
+	}()
+	if !waitFor(time.Second*5, func() bool {
+		return process.IsRunning() && isListening(process)
+	}) {
+		t.Errorf("expected process to be started")
+		return
+	}
+
+	// crash, then stop before the restart
+	killPid(process.Pid())
+	if !waitFor(time.Second*5, func() bool { return !process.IsRunning() }) {
+		t.Errorf("expected process to be killed")
+		return
+	}
+	process.Stop()
+	select {
+	case err := <-supervised:
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+	case <-time.After(time.Second * 5):
+		t.Errorf("timed out waiting for Supervise to return")
+	}
+	if process.IsRunning() {
+		t.Errorf("expected process not to be restarted")
+		process.Stop()
+		process.Wait()
+	}
+}
+
+func TestProcess_SuperviseMaxRestarts(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.supervisemax")
+	process.User = username
+	process.RestartBackoffInitial = time.Millisecond * 10
+	process.MaxRestarts = 1
+	if err := process.SaveConfig(basepath + "/etc/test.supervisemax.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	supervised := make(chan error, 1)
+	go func() {
+		supervised <- process.Supervise(context.Background())
+	}()
+	for i, pid := 0, -1; i < 2; i++ {
+		if !waitFor(time.Second*5, func() bool {
+			return process.Pid() != pid && process.IsRunning() && isListening(process)
+		}) {
+			t.Errorf("expected process to be started")
+			return
+		}
+		pid = process.Pid()
+		killPid(pid)
+	}
+
+	select {
+	case err := <-supervised:
+		if err == nil {
+			t.Errorf("expected error, got nil")
+		}
+	case <-time.After(time.Second * 5):
+		t.Errorf("timed out waiting for Supervise to return")
+	}
+}
+
+func TestProcess_SuperviseNotReady(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.supervisenotready")
+	process.User = username
+
+	// never logs to stderr, as ErrorLog is a file
+	process.ReadinessMode = gophpfpm.ReadinessLog
+	process.StartTimeout = time.Millisecond * 200
+	if err := process.SaveConfig(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if err := process.Supervise(context.Background()); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if process.IsRunning() {
+		t.Errorf("expected the process not ready to be killed")
+	}
+}
+
+func TestProcess_SuperviseAlreadyStarted(t *testing.T) {
+	process := gophpfpm.NewProcess(pathToPhpFpm)
+	process.SetDatadirNamed(basepath+"/var", "test.supervisestarted")
+	process.User = username
+	process.StdoutTo = ioutil.Discard
+	process.StderrTo = ioutil.Discard
+	if err := process.SaveConfig(basepath + "/etc/test.supervisestarted.conf"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, _, err := process.Start(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	defer func() {
+		process.Stop()
+		process.Wait()
+	}()
+
+	// the running process is left alone
+	if want, have := gophpfpm.ErrAlreadyStarted, process.Supervise(context.Background()); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if !process.IsRunning() {
+		t.Errorf("expected process to be running")
+	}
+}